@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/araminian/cube/manager"
+	"github.com/araminian/cube/node"
+	"github.com/araminian/cube/task"
+	"github.com/google/uuid"
+)
+
+// client talks to the manager API at addr.
+type client struct {
+	addr string
+	http *http.Client
+}
+
+func newClient(addr string) *client {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &client{
+		addr: strings.TrimSuffix(addr, "/"),
+		http: &http.Client{},
+	}
+}
+
+// do sends a request with body encoded as JSON, when it is not nil, and
+// decodes the response into out, when it is not nil. Error responses of the
+// manager are returned as errors.
+func (c *client) do(method, path string, body any, out any) error {
+	resp, err := c.send(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("decoding response of %s %s: %v", method, path, err)
+	}
+	return nil
+}
+
+// send sends a request and returns the response if it succeeded. The caller
+// must close its body.
+func (c *client) send(method, path string, body any) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.addr+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+func responseError(resp *http.Response) error {
+	data, _ := io.ReadAll(resp.Body)
+	var e manager.ErrResponse
+	if json.Unmarshal(data, &e) == nil && e.Message != "" {
+		return fmt.Errorf("%s", e.Message)
+	}
+	if msg := strings.TrimSpace(string(data)); msg != "" {
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	return fmt.Errorf("%s", resp.Status)
+}
+
+func (c *client) submitTask(te task.TaskEvent) (task.Task, error) {
+	var t task.Task
+	err := c.do(http.MethodPost, "/tasks", te, &t)
+	return t, err
+}
+
+func (c *client) tasks() ([]task.Task, error) {
+	var tasks []task.Task
+	err := c.do(http.MethodGet, "/tasks", nil, &tasks)
+	return tasks, err
+}
+
+func (c *client) task(id uuid.UUID) (task.Task, error) {
+	var t task.Task
+	err := c.do(http.MethodGet, "/tasks/"+id.String(), nil, &t)
+	return t, err
+}
+
+func (c *client) stopTask(id uuid.UUID) error {
+	return c.do(http.MethodDelete, "/tasks/"+id.String(), nil, nil)
+}
+
+// logs returns the logs of the task, the caller must close them.
+func (c *client) logs(id uuid.UUID, query url.Values) (io.ReadCloser, error) {
+	resp, err := c.send(http.MethodGet, "/tasks/"+id.String()+"/logs?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *client) nodes() ([]node.Node, error) {
+	var nodes []node.Node
+	err := c.do(http.MethodGet, "/nodes", nil, &nodes)
+	return nodes, err
+}
+
+func (c *client) events(taskID uuid.UUID) ([]task.TaskEvent, error) {
+	path := "/events"
+	if taskID != uuid.Nil {
+		path += "?task=" + taskID.String()
+	}
+	var events []task.TaskEvent
+	err := c.do(http.MethodGet, path, nil, &events)
+	return events, err
+}
+
+// resolveTask returns the id of the task ref refers to, which is either a
+// full task id, a unique prefix of one or the unique name of a task.
+func (c *client) resolveTask(ref string) (uuid.UUID, error) {
+	if id, err := uuid.Parse(ref); err == nil {
+		return id, nil
+	}
+
+	tasks, err := c.tasks()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	var matches []uuid.UUID
+	for _, t := range tasks {
+		if strings.HasPrefix(t.ID.String(), ref) || t.Name == ref {
+			matches = append(matches, t.ID)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return uuid.Nil, fmt.Errorf("no such task: %s", ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return uuid.Nil, fmt.Errorf("%q matches %d tasks, use a longer id", ref, len(matches))
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araminian/cube/task"
+	"github.com/docker/go-units"
+	"github.com/google/uuid"
+)
+
+// stringsFlag collects the values of a flag that may be repeated.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func newFlagSet(name, args, help string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: cube %s %s\n\n%s\n\nFlags:\n", name, args, help)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func runCmd(c *client, args []string) error {
+	fs := newFlagSet("run", "[flags] [IMAGE [COMMAND [ARG...]]]",
+		"Submits a task. Flags and arguments override the fields of the spec file.")
+	spec := fs.String("f", "", "YAML or JSON `file` with the task spec, - reads stdin")
+	name := fs.String("name", "", "name of the task")
+	var env, ports stringsFlag
+	fs.Var(&env, "e", "set an environment variable, `KEY=VALUE` (repeatable)")
+	fs.Var(&ports, "p", "publish a container port, `[HOST:]PORT[/PROTO]` (repeatable)")
+	cpu := fs.Float64("cpu", 0, "number of CPUs the task may use")
+	memory := fs.String("memory", "", "memory limit, e.g. 512m")
+	workdir := fs.String("workdir", "", "working directory of the command")
+	user := fs.String("user", "", "user the command runs as")
+	job := fs.Bool("job", false, "run the task as a job that is expected to exit")
+	restarts := fs.Int("restarts", 0, "how often the task is restarted after it failed, -1 never")
+	output := outputFlag(fs)
+	fs.Parse(args)
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	var t task.Task
+	if *spec != "" {
+		var err error
+		t, err = readSpec(*spec)
+		if err != nil {
+			return err
+		}
+	}
+	if fs.NArg() > 0 {
+		t.Image = fs.Arg(0)
+	}
+	if fs.NArg() > 1 {
+		t.Cmd = fs.Args()[1:]
+	}
+	if t.Image == "" {
+		fs.Usage()
+		return fmt.Errorf("an image or a spec file with an image is required")
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "name":
+			t.Name = *name
+		case "cpu":
+			t.Cpu = *cpu
+		case "memory":
+			var n int64
+			n, err = units.RAMInBytes(*memory)
+			t.Memory = int(n)
+		case "workdir":
+			t.WorkingDir = *workdir
+		case "user":
+			t.User = *user
+		case "job":
+			if *job {
+				t.Mode = task.ModeJob
+			} else {
+				t.Mode = task.ModeService
+			}
+		case "restarts":
+			t.MaxRestarts = *restarts
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("invalid memory %q: %v", *memory, err)
+	}
+	t.Env = append(t.Env, env...)
+	for _, p := range ports {
+		if t.PortBindings == nil {
+			t.PortBindings = make(map[string]string)
+		}
+		host, port, found := strings.Cut(p, ":")
+		if !found {
+			host, port = "", p
+		}
+		t.PortBindings[port] = host
+	}
+
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	t.State = task.Pending
+	t, err = c.submitTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Scheduled,
+		Timestamp: time.Now(),
+		Task:      t,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *output == "json" {
+		return printJSON(t)
+	}
+	fmt.Println(t.ID)
+	return nil
+}
+
+func psCmd(c *client, args []string) error {
+	fs := newFlagSet("ps", "[flags]", "Lists the tasks that have not finished.")
+	all := fs.Bool("a", false, "also list completed and failed tasks")
+	output := outputFlag(fs)
+	fs.Parse(args)
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	tasks, err := c.tasks()
+	if err != nil {
+		return err
+	}
+	if !*all {
+		tasks = slices.DeleteFunc(tasks, func(t task.Task) bool {
+			return t.State == task.Completed || t.State == task.Failed
+		})
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].Name != tasks[j].Name {
+			return tasks[i].Name < tasks[j].Name
+		}
+		return tasks[i].ID.String() < tasks[j].ID.String()
+	})
+	if *output == "json" {
+		return printJSON(tasks)
+	}
+
+	var rows [][]string
+	for _, t := range tasks {
+		state := t.State.String()
+		if t.Health != task.HealthUnknown {
+			state += " (" + string(t.Health) + ")"
+		}
+		rows = append(rows, []string{
+			shortID(t.ID), orDash(t.Name), t.Image, state, orDash(t.Worker), orDash(formatPorts(t)), ago(t.StartTime),
+		})
+	}
+	return printTable([]string{"ID", "NAME", "IMAGE", "STATE", "WORKER", "PORTS", "STARTED"}, rows)
+}
+
+// formatPorts lists the host ports the ports of t are published on, e.g.
+// "8080->80/tcp".
+func formatPorts(t task.Task) string {
+	var ports []string
+	for port, host := range t.HostPorts {
+		ports = append(ports, fmt.Sprintf("%s->%s", host, port))
+	}
+	sort.Strings(ports)
+	return strings.Join(ports, ", ")
+}
+
+func inspectCmd(c *client, args []string) error {
+	fs := newFlagSet("inspect", "TASK [TASK...]", "Prints tasks as JSON. TASK is an id, a unique id prefix or a name.")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("a task is required")
+	}
+
+	var tasks []task.Task
+	for _, ref := range fs.Args() {
+		id, err := c.resolveTask(ref)
+		if err != nil {
+			return err
+		}
+		t, err := c.task(id)
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, t)
+	}
+	return printJSON(tasks)
+}
+
+func stopCmd(c *client, args []string) error {
+	fs := newFlagSet("stop", "TASK [TASK...]", "Stops tasks. TASK is an id, a unique id prefix or a name.")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("a task is required")
+	}
+
+	for _, ref := range fs.Args() {
+		id, err := c.resolveTask(ref)
+		if err != nil {
+			return err
+		}
+		err = c.stopTask(id)
+		if err != nil {
+			return err
+		}
+		fmt.Println(id)
+	}
+	return nil
+}
+
+func logsCmd(c *client, args []string) error {
+	fs := newFlagSet("logs", "[flags] TASK", "Prints the logs of a task. TASK is an id, a unique id prefix or a name.")
+	follow := fs.Bool("f", false, "keep printing new output")
+	tail := fs.String("tail", "all", "number of lines to print from the end of the logs")
+	since := fs.String("since", "", "only print logs since a time or a duration ago, e.g. 10m")
+	timestamps := fs.Bool("t", false, "print timestamps")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("exactly one task is required")
+	}
+
+	id, err := c.resolveTask(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	q := url.Values{}
+	q.Set("tail", *tail)
+	q.Set("follow", strconv.FormatBool(*follow))
+	q.Set("timestamps", strconv.FormatBool(*timestamps))
+	if *since != "" {
+		q.Set("since", *since)
+	}
+	logs, err := c.logs(id, q)
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+	_, err = io.Copy(os.Stdout, logs)
+	return err
+}
+
+func nodesCmd(c *client, args []string) error {
+	fs := newFlagSet("nodes", "[flags]", "Lists the worker nodes.")
+	output := outputFlag(fs)
+	fs.Parse(args)
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	nodes, err := c.nodes()
+	if err != nil {
+		return err
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	if *output == "json" {
+		return printJSON(nodes)
+	}
+
+	var rows [][]string
+	for _, n := range nodes {
+		rows = append(rows, []string{
+			n.Name,
+			n.Api,
+			string(n.State),
+			strconv.Itoa(n.Cores),
+			units.BytesSize(float64(n.MemoryAllocated)) + "/" + units.BytesSize(float64(n.Memory)),
+			units.BytesSize(float64(n.DiskAllocated)) + "/" + units.BytesSize(float64(n.Disk)),
+			strconv.Itoa(n.TaskCounts),
+			ago(n.LastSeen),
+		})
+	}
+	return printTable([]string{"NAME", "API", "STATE", "CPUS", "MEMORY", "DISK", "TASKS", "LAST SEEN"}, rows)
+}
+
+func eventsCmd(c *client, args []string) error {
+	fs := newFlagSet("events", "[flags]", "Lists the task events the manager sent to workers, oldest first.")
+	ref := fs.String("task", "", "only list the events of this `task`")
+	output := outputFlag(fs)
+	fs.Parse(args)
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	var taskID uuid.UUID
+	if *ref != "" {
+		var err error
+		taskID, err = c.resolveTask(*ref)
+		if err != nil {
+			return err
+		}
+	}
+	events, err := c.events(taskID)
+	if err != nil {
+		return err
+	}
+	if *output == "json" {
+		return printJSON(events)
+	}
+
+	var rows [][]string
+	for _, te := range events {
+		rows = append(rows, []string{
+			te.Timestamp.Local().Format(time.DateTime),
+			shortID(te.ID),
+			shortID(te.Task.ID),
+			orDash(te.Task.Name),
+			te.State.String(),
+			orDash(te.Task.Worker),
+		})
+	}
+	return printTable([]string{"TIME", "EVENT", "TASK", "NAME", "STATE", "WORKER"}, rows)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/araminian/cube/task"
+)
+
+// run runs "cube run" with args against a fake manager and returns the task
+// it submitted.
+func run(t *testing.T, args ...string) (task.Task, error) {
+	t.Helper()
+	var submitted task.Task
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var te task.TaskEvent
+		if err := json.NewDecoder(r.Body).Decode(&te); err != nil {
+			t.Errorf("decoding submitted task: %v", err)
+		}
+		submitted = te.Task
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(te.Task)
+	}))
+	defer srv.Close()
+
+	err := runCmd(newClient(srv.URL), args)
+	return submitted, err
+}
+
+func writeSpec(t *testing.T, spec string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "spec.yaml")
+	if err := os.WriteFile(path, []byte(spec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunPorts(t *testing.T) {
+	tk, err := run(t, "-p", "8080:80", "-p", "53/udp", "-p", "9000:9000/udp", "-p", "443", "nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"80": "8080", "53/udp": "", "9000/udp": "9000", "443": ""}
+	if len(tk.PortBindings) != len(want) {
+		t.Errorf("ports are bound as %v, want %v", tk.PortBindings, want)
+	}
+	for port, host := range want {
+		if got, ok := tk.PortBindings[port]; !ok || got != host {
+			t.Errorf("port %s is bound to %q, want %q", port, got, host)
+		}
+	}
+}
+
+func TestRunMemory(t *testing.T) {
+	tests := []struct {
+		memory string
+		want   int
+	}{
+		{"512m", 512 << 20},
+		{"512M", 512 << 20},
+		{"1g", 1 << 30},
+		{"64k", 64 << 10},
+		{"1024", 1024},
+	}
+	for _, tt := range tests {
+		tk, err := run(t, "-memory", tt.memory, "nginx")
+		if err != nil {
+			t.Errorf("-memory %s: %v", tt.memory, err)
+			continue
+		}
+		if tk.Memory != tt.want {
+			t.Errorf("-memory %s is %d bytes, want %d", tt.memory, tk.Memory, tt.want)
+		}
+	}
+
+	if _, err := run(t, "-memory", "lots", "nginx"); err == nil || !strings.Contains(err.Error(), `invalid memory "lots"`) {
+		t.Errorf("-memory lots: got error %v", err)
+	}
+}
+
+func TestRunFlagsOverrideSpec(t *testing.T) {
+	spec := writeSpec(t, `
+name: web
+image: nginx
+cmd: [nginx, -g, daemon off;]
+env: [A=1]
+memory: 1024
+mode: job
+maxRestarts: 3
+portBindings: {"80": "8080"}
+`)
+
+	// Without flags the spec is submitted as it is.
+	tk, err := run(t, "-f", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Name != "web" || tk.Image != "nginx" || tk.Memory != 1024 || tk.Mode != task.ModeJob || tk.MaxRestarts != 3 {
+		t.Errorf("spec was submitted as %+v", tk)
+	}
+
+	tk, err = run(t, "-f", spec, "-name", "api", "-memory", "2k", "-job=false", "-restarts", "-1", "-e", "B=2", "-p", "443:443", "httpd", "httpd-foreground")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Name != "api" || tk.Image != "httpd" || tk.Memory != 2048 {
+		t.Errorf("task is %s from %s with %d bytes, want api from httpd with 2048", tk.Name, tk.Image, tk.Memory)
+	}
+	if tk.Mode != task.ModeService || tk.MaxRestarts != -1 {
+		t.Errorf("task is in mode %q with %d restarts, want a service that is never restarted", tk.Mode, tk.MaxRestarts)
+	}
+	if !slices.Equal(tk.Cmd, []string{"httpd-foreground"}) {
+		t.Errorf("task runs %q, want httpd-foreground", tk.Cmd)
+	}
+	// Environment variables and ports are added to those of the spec.
+	if !slices.Equal(tk.Env, []string{"A=1", "B=2"}) {
+		t.Errorf("task environment is %q", tk.Env)
+	}
+	if tk.PortBindings["80"] != "8080" || tk.PortBindings["443"] != "443" {
+		t.Errorf("task binds ports %v", tk.PortBindings)
+	}
+
+	tk, err = run(t, "-f", writeSpec(t, "image: nginx\n"), "-job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Mode != task.ModeJob {
+		t.Errorf("-job runs the task in mode %q", tk.Mode)
+	}
+}
+
+func TestRunRequiresImage(t *testing.T) {
+	if _, err := run(t, "-f", writeSpec(t, "name: web\n")); err == nil {
+		t.Error("running a spec without image succeeded")
+	}
+}
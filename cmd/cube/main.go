@@ -0,0 +1,64 @@
+// Command cube is a command-line client for the cube manager API.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+const usage = `Usage: cube [-manager URL] COMMAND [ARGS]
+
+Commands:
+  run      Submit a task from flags or a YAML/JSON spec file
+  ps       List tasks
+  inspect  Show tasks as JSON
+  stop     Stop tasks
+  logs     Print the logs of a task
+  nodes    List worker nodes
+  events   List task events
+
+Run 'cube COMMAND -h' for the flags of a command.
+`
+
+var commands = map[string]func(c *client, args []string) error{
+	"run":     runCmd,
+	"ps":      psCmd,
+	"inspect": inspectCmd,
+	"stop":    stopCmd,
+	"logs":    logsCmd,
+	"nodes":   nodesCmd,
+	"events":  eventsCmd,
+}
+
+func main() {
+	manager := os.Getenv("CUBE_MANAGER")
+	if manager == "" {
+		manager = "http://localhost:5556"
+	}
+
+	flag.StringVar(&manager, "manager", manager, "address of the manager API, defaults to $CUBE_MANAGER")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fmt.Fprintln(os.Stderr, "\nFlags:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "cube: unknown command %q\n\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := cmd(newClient(manager), flag.Args()[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cube: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/araminian/cube/task"
+	"gopkg.in/yaml.v3"
+)
+
+// readSpec reads a task from a YAML or JSON file, or from stdin when path
+// is "-". Keys are the fields of task.Task and match regardless of case, so
+// both "portBindings" and "PortBindings" work:
+//
+//	name: web
+//	image: nginx:1.27
+//	env: [MODE=production]
+//	portBindings: {"80/tcp": "8080"}
+//	healthCheck: {type: http, path: /, port: 80}
+func readSpec(path string) (task.Task, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return task.Task{}, err
+	}
+
+	t, err := parseSpec(data)
+	if err != nil {
+		return task.Task{}, fmt.Errorf("reading spec %s: %v", path, err)
+	}
+	return t, nil
+}
+
+// parseSpec decodes a spec through JSON, which YAML is a superset of, so
+// that it is read with the JSON field names of task.Task.
+func parseSpec(data []byte) (task.Task, error) {
+	var raw any
+	err := yaml.Unmarshal(data, &raw)
+	if err != nil {
+		return task.Task{}, err
+	}
+	if raw == nil {
+		return task.Task{}, fmt.Errorf("spec is empty")
+	}
+	data, err = json.Marshal(raw)
+	if err != nil {
+		return task.Task{}, err
+	}
+
+	var t task.Task
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	err = d.Decode(&t)
+	if err != nil {
+		return task.Task{}, err
+	}
+	return t, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/araminian/cube/task"
+)
+
+func TestParseSpec(t *testing.T) {
+	yaml := `
+name: web
+image: nginx:1.27
+cmd: [nginx]
+args: ["-g", "daemon off;"]
+env: [MODE=production]
+memory: 268435456
+mode: job
+maxRestarts: 3
+portBindings: {"80/tcp": "8080"}
+mounts:
+  - {type: volume, source: data, target: /data}
+healthCheck: {type: http, path: /, port: 80}
+`
+	json := `{"Name": "web", "Image": "nginx:1.27", "Cmd": ["nginx"], "Args": ["-g", "daemon off;"],
+		"Env": ["MODE=production"], "Memory": 268435456, "Mode": "job", "MaxRestarts": 3,
+		"PortBindings": {"80/tcp": "8080"}, "Mounts": [{"Type": "volume", "Source": "data", "Target": "/data"}],
+		"HealthCheck": {"Type": "http", "Path": "/", "Port": 80}}`
+
+	for name, spec := range map[string]string{"yaml": yaml, "json": json} {
+		tk, err := parseSpec([]byte(spec))
+		if err != nil {
+			t.Errorf("parsing %s spec: %v", name, err)
+			continue
+		}
+		if tk.Name != "web" || tk.Image != "nginx:1.27" || tk.Memory != 256<<20 || tk.Mode != task.ModeJob || tk.MaxRestarts != 3 {
+			t.Errorf("%s spec is %+v", name, tk)
+		}
+		if !slices.Equal(tk.Cmd, []string{"nginx"}) || !slices.Equal(tk.Args, []string{"-g", "daemon off;"}) || !slices.Equal(tk.Env, []string{"MODE=production"}) {
+			t.Errorf("%s spec runs %q %q with %q", name, tk.Cmd, tk.Args, tk.Env)
+		}
+		if tk.PortBindings["80/tcp"] != "8080" {
+			t.Errorf("%s spec binds ports %v", name, tk.PortBindings)
+		}
+		if len(tk.Mounts) != 1 || tk.Mounts[0] != (task.Mount{Type: task.MountVolume, Source: "data", Target: "/data"}) {
+			t.Errorf("%s spec mounts %+v", name, tk.Mounts)
+		}
+		if tk.HealthCheck == nil || tk.HealthCheck.Path != "/" || tk.HealthCheck.Port != 80 {
+			t.Errorf("%s spec checks health with %+v", name, tk.HealthCheck)
+		}
+	}
+}
+
+func TestParseSpecErrors(t *testing.T) {
+	tests := []struct {
+		spec, err string
+	}{
+		{"", "spec is empty"},
+		{"# nothing\n", "spec is empty"},
+		{"image: nginx\nreplicas: 3\n", `unknown field "replicas"`},
+		{"image: [nginx\n", "yaml"},
+		{"- nginx\n", "cannot unmarshal array"},
+		{"image: nginx\nmemory: lots\n", "cannot unmarshal string"},
+	}
+	for _, tt := range tests {
+		_, err := parseSpec([]byte(tt.spec))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parsing %q: got error %v, want one containing %q", tt.spec, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araminian/cube/worker"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of a cube process. It is read from a YAML
+// file, then from CUBE_* environment variables and finally from flags, each
+// overriding the previous one. Durations left at zero keep the defaults of
+// the manager and worker.
+//
+//	dataDir: /var/lib/cube
+//	manager:
+//	  listen: 0.0.0.0:5556
+//	  scheduler: epvm
+//	  parallelism: 4
+//	  intervals:
+//	    updateTasks: 15s
+//	worker:
+//	  name: node-1
+//	  listen: 0.0.0.0:5555
+//	  advertise: http://10.0.0.11:5555
+//	  join: http://10.0.0.10:5556
+//	  parallelism: 2
+type Config struct {
+	// DataDir is where state is kept across restarts, in memory when it
+	// is empty.
+	DataDir     string        `yaml:"dataDir"`
+	DisableExec bool          `yaml:"disableExec"`
+	Manager     ManagerConfig `yaml:"manager"`
+	Worker      WorkerConfig  `yaml:"worker"`
+}
+
+type ManagerConfig struct {
+	// Listen is the host:port the manager API listens on.
+	Listen    string `yaml:"listen"`
+	Scheduler string `yaml:"scheduler"`
+	// Workers are the host:port addresses of workers that don't join the
+	// manager on their own. Their stats are polled instead.
+	Workers             []string      `yaml:"workers"`
+	HeartbeatTimeout    time.Duration `yaml:"heartbeatTimeout"`
+	LostTaskGracePeriod time.Duration `yaml:"lostTaskGracePeriod"`
+	RetryDelay          time.Duration `yaml:"retryDelay"`
+	// Parallelism is how many tasks are placed at once, zero keeps the
+	// default.
+	Parallelism int              `yaml:"parallelism"`
+	Intervals   ManagerIntervals `yaml:"intervals"`
+}
+
+// ManagerIntervals are the intervals at which the loops of the manager run.
+type ManagerIntervals struct {
+	UpdateTasks  time.Duration `yaml:"updateTasks"`
+	NodeStats    time.Duration `yaml:"nodeStats"`
+	CheckNodes   time.Duration `yaml:"checkNodes"`
+	RestartTasks time.Duration `yaml:"restartTasks"`
+	Services     time.Duration `yaml:"services"`
+	CronJobs     time.Duration `yaml:"cronJobs"`
+	Workflows    time.Duration `yaml:"workflows"`
+}
+
+type WorkerConfig struct {
+	// Name identifies the worker to the manager, it defaults to the host
+	// name.
+	Name string `yaml:"name"`
+	// Listen is the host:port the worker API listens on and Advertise the
+	// URL the manager reaches it at, by default http:// and Listen.
+	Listen    string `yaml:"listen"`
+	Advertise string `yaml:"advertise"`
+	// Join is the URL of the manager the worker registers with. Without
+	// it the worker only serves its API, for managers that list it in
+	// their workers.
+	Join         string `yaml:"join"`
+	Runtime      string `yaml:"runtime"`
+	OrphanPolicy string `yaml:"orphanPolicy"`
+	// Parallelism is how many tasks are started or stopped at once, zero
+	// keeps the default.
+	Parallelism int             `yaml:"parallelism"`
+	Intervals   WorkerIntervals `yaml:"intervals"`
+}
+
+// WorkerIntervals are the intervals at which the loops of the worker run.
+type WorkerIntervals struct {
+	Heartbeat time.Duration `yaml:"heartbeat"`
+	Stats     time.Duration `yaml:"stats"`
+	Inspect   time.Duration `yaml:"inspect"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Manager: ManagerConfig{
+			Listen: "localhost:5556",
+		},
+		Worker: WorkerConfig{
+			Listen: "localhost:5555",
+			Intervals: WorkerIntervals{
+				Heartbeat: 10 * time.Second,
+			},
+		},
+	}
+}
+
+// loadConfig builds the configuration of a process from the file named by
+// -config or CUBE_CONFIG, the environment and the flags in args.
+func loadConfig(name string, args []string) (Config, error) {
+	cfg := defaultConfig()
+	var path string
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&path, "config", os.Getenv("CUBE_CONFIG"), "YAML configuration `file`")
+	cfg.bindFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), usage, filepath.Base(os.Args[0]))
+		fmt.Fprintln(fs.Output(), "\nFlags:")
+		fs.PrintDefaults()
+	}
+
+	// Flags are parsed twice: once to find the configuration file and once
+	// more after reading it and the environment, so that they take
+	// precedence over both.
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		err = yaml.Unmarshal(data, &cfg)
+		if err != nil {
+			return Config{}, fmt.Errorf("reading config %s: %v", path, err)
+		}
+	}
+	err := cfg.readEnv()
+	if err != nil {
+		return Config{}, err
+	}
+	fs.Parse(args)
+	if cfg.Manager.Parallelism < 0 || cfg.Worker.Parallelism < 0 {
+		return Config{}, fmt.Errorf("parallelism must not be negative")
+	}
+	policy, err := worker.ParseOrphanPolicy(cfg.Worker.OrphanPolicy)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.Worker.OrphanPolicy = string(policy)
+	return cfg, nil
+}
+
+func (c *Config) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.DataDir, "data-dir", c.DataDir, "`directory` to keep state in, in memory when empty")
+	fs.BoolVar(&c.DisableExec, "disable-exec", c.DisableExec, "reject requests to run commands in tasks")
+
+	fs.StringVar(&c.Manager.Listen, "manager-listen", c.Manager.Listen, "`host:port` the manager API listens on")
+	fs.StringVar(&c.Manager.Scheduler, "scheduler", c.Manager.Scheduler, "scheduler placing tasks: roundrobin, epvm, binpack or leastloaded")
+	fs.Var((*listFlag)(&c.Manager.Workers), "workers", "comma separated `host:port` addresses of workers the manager polls")
+	fs.DurationVar(&c.Manager.HeartbeatTimeout, "heartbeat-timeout", c.Manager.HeartbeatTimeout, "time after which a silent worker is unreachable")
+	fs.DurationVar(&c.Manager.LostTaskGracePeriod, "lost-task-grace-period", c.Manager.LostTaskGracePeriod, "time after which the tasks of an unreachable worker are rescheduled")
+	fs.DurationVar(&c.Manager.RetryDelay, "retry-delay", c.Manager.RetryDelay, "time before placing a task that could not be placed is retried")
+	fs.IntVar(&c.Manager.Parallelism, "manager-parallelism", c.Manager.Parallelism, "number of tasks the manager places at once")
+	fs.DurationVar(&c.Manager.Intervals.UpdateTasks, "update-tasks-interval", c.Manager.Intervals.UpdateTasks, "interval at which task states are fetched from workers")
+	fs.DurationVar(&c.Manager.Intervals.NodeStats, "node-stats-interval", c.Manager.Intervals.NodeStats, "interval at which worker stats are collected")
+	fs.DurationVar(&c.Manager.Intervals.CheckNodes, "check-nodes-interval", c.Manager.Intervals.CheckNodes, "interval at which worker heartbeats are checked")
+	fs.DurationVar(&c.Manager.Intervals.RestartTasks, "restart-tasks-interval", c.Manager.Intervals.RestartTasks, "interval at which failed tasks are restarted")
+	fs.DurationVar(&c.Manager.Intervals.Services, "services-interval", c.Manager.Intervals.Services, "interval at which services are reconciled")
+	fs.DurationVar(&c.Manager.Intervals.CronJobs, "cron-jobs-interval", c.Manager.Intervals.CronJobs, "interval at which cron jobs are run")
+	fs.DurationVar(&c.Manager.Intervals.Workflows, "workflows-interval", c.Manager.Intervals.Workflows, "interval at which workflows are advanced")
+
+	fs.StringVar(&c.Worker.Name, "worker-name", c.Worker.Name, "name of the worker, the host name when empty")
+	fs.StringVar(&c.Worker.Listen, "worker-listen", c.Worker.Listen, "`host:port` the worker API listens on")
+	fs.StringVar(&c.Worker.Advertise, "advertise", c.Worker.Advertise, "`URL` the manager reaches the worker at")
+	fs.StringVar(&c.Worker.Join, "join", c.Worker.Join, "`URL` of the manager the worker registers with")
+	fs.StringVar(&c.Worker.Runtime, "runtime", c.Worker.Runtime, "runtime running tasks: docker, process or fake")
+	fs.StringVar(&c.Worker.OrphanPolicy, "orphan-policy", c.Worker.OrphanPolicy, "what to do with containers of unknown tasks: keep or remove")
+	fs.IntVar(&c.Worker.Parallelism, "worker-parallelism", c.Worker.Parallelism, "number of tasks the worker starts or stops at once")
+	fs.DurationVar(&c.Worker.Intervals.Heartbeat, "heartbeat-interval", c.Worker.Intervals.Heartbeat, "interval at which heartbeats are sent to the manager")
+	fs.DurationVar(&c.Worker.Intervals.Stats, "stats-interval", c.Worker.Intervals.Stats, "interval at which machine stats are collected")
+	fs.DurationVar(&c.Worker.Intervals.Inspect, "inspect-interval", c.Worker.Intervals.Inspect, "interval at which the containers of running tasks are inspected")
+}
+
+// readEnv overrides the configuration with the CUBE_* environment variables
+// that are set.
+func (c *Config) readEnv() error {
+	vars := map[string]*string{
+		"CUBE_DATA_DIR":         &c.DataDir,
+		"CUBE_MANAGER_LISTEN":   &c.Manager.Listen,
+		"CUBE_SCHEDULER":        &c.Manager.Scheduler,
+		"CUBE_WORKER_NAME":      &c.Worker.Name,
+		"CUBE_WORKER_LISTEN":    &c.Worker.Listen,
+		"CUBE_WORKER_ADVERTISE": &c.Worker.Advertise,
+		"CUBE_JOIN":             &c.Worker.Join,
+		"CUBE_RUNTIME":          &c.Worker.Runtime,
+		"CUBE_ORPHAN_POLICY":    &c.Worker.OrphanPolicy,
+	}
+	for name, v := range vars {
+		if s, ok := os.LookupEnv(name); ok {
+			*v = s
+		}
+	}
+	if s, ok := os.LookupEnv("CUBE_WORKERS"); ok {
+		(*listFlag)(&c.Manager.Workers).Set(s)
+	}
+	ints := map[string]*int{
+		"CUBE_MANAGER_PARALLELISM": &c.Manager.Parallelism,
+		"CUBE_WORKER_PARALLELISM":  &c.Worker.Parallelism,
+	}
+	for name, v := range ints {
+		if s, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", name, s)
+			}
+			*v = n
+		}
+	}
+	if s, ok := os.LookupEnv("CUBE_DISABLE_EXEC"); ok {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid CUBE_DISABLE_EXEC %q", s)
+		}
+		c.DisableExec = b
+	}
+	return nil
+}
+
+// listFlag is a comma separated list of values.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = nil
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// splitListen splits a listen address into the host and port the APIs
+// take.
+func splitListen(addr string) (string, int, error) {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid listen address %q: %v", addr, err)
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in listen address %q", addr)
+	}
+	return host, port, nil
+}
+
+// reachableURL turns a listen address into a URL other processes can use,
+// replacing a wildcard host with the host name.
+func reachableURL(addr string) (string, error) {
+	host, port, err := splitListen(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host, err = os.Hostname()
+		if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("http://%s", net.JoinHostPort(host, strconv.Itoa(port))), nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv unsets the CUBE_* variables for the duration of the test.
+func clearEnv(t *testing.T) {
+	for _, e := range os.Environ() {
+		name, _, _ := strings.Cut(e, "=")
+		if strings.HasPrefix(name, "CUBE_") {
+			t.Setenv(name, "")
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func writeConfig(t *testing.T, config string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cube.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	clearEnv(t)
+	cfg, err := loadConfig("cube", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Manager.Listen != "localhost:5556" || cfg.Worker.Listen != "localhost:5555" {
+		t.Errorf("manager listens on %q and worker on %q", cfg.Manager.Listen, cfg.Worker.Listen)
+	}
+	if cfg.Worker.Intervals.Heartbeat != 10*time.Second || cfg.Worker.OrphanPolicy != "keep" {
+		t.Errorf("worker sends heartbeats every %v and orphan policy is %q", cfg.Worker.Intervals.Heartbeat, cfg.Worker.OrphanPolicy)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	clearEnv(t)
+	path := writeConfig(t, `
+dataDir: /var/lib/cube
+manager:
+  listen: 0.0.0.0:7000
+  scheduler: epvm
+  workers: [a:5555]
+  parallelism: 4
+  intervals:
+    updateTasks: 15s
+worker:
+  name: file
+  parallelism: 2
+  orphanPolicy: remove
+`)
+
+	// The file overrides the defaults.
+	cfg, err := loadConfig("cube", []string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DataDir != "/var/lib/cube" || cfg.Manager.Listen != "0.0.0.0:7000" || cfg.Manager.Scheduler != "epvm" {
+		t.Errorf("configuration from file is %+v", cfg)
+	}
+	if cfg.Manager.Intervals.UpdateTasks != 15*time.Second || cfg.Worker.Intervals.Heartbeat != 10*time.Second {
+		t.Errorf("intervals are %+v and %+v", cfg.Manager.Intervals, cfg.Worker.Intervals)
+	}
+
+	// The environment overrides the file, CUBE_CONFIG names it.
+	t.Setenv("CUBE_CONFIG", path)
+	t.Setenv("CUBE_SCHEDULER", "binpack")
+	t.Setenv("CUBE_WORKERS", "b:5555, c:5555")
+	t.Setenv("CUBE_MANAGER_PARALLELISM", "8")
+	t.Setenv("CUBE_WORKER_NAME", "env")
+	t.Setenv("CUBE_DISABLE_EXEC", "true")
+	cfg, err = loadConfig("cube", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Manager.Scheduler != "binpack" || cfg.Manager.Parallelism != 8 || cfg.Worker.Name != "env" || !cfg.DisableExec {
+		t.Errorf("configuration from environment is %+v", cfg)
+	}
+	if !slices.Equal(cfg.Manager.Workers, []string{"b:5555", "c:5555"}) {
+		t.Errorf("workers from environment are %q", cfg.Manager.Workers)
+	}
+	if cfg.Manager.Listen != "0.0.0.0:7000" || cfg.Worker.Parallelism != 2 || cfg.Worker.OrphanPolicy != "remove" {
+		t.Errorf("configuration from file was lost: %+v", cfg)
+	}
+
+	// Flags override both.
+	cfg, err = loadConfig("cube", []string{
+		"-scheduler", "leastloaded",
+		"-workers", "d:5555",
+		"-manager-parallelism", "16",
+		"-worker-name", "flag",
+		"-disable-exec=false",
+		"-update-tasks-interval", "1m",
+		"-orphan-policy", "keep",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Manager.Scheduler != "leastloaded" || cfg.Manager.Parallelism != 16 || cfg.Worker.Name != "flag" || cfg.DisableExec {
+		t.Errorf("configuration from flags is %+v", cfg)
+	}
+	if !slices.Equal(cfg.Manager.Workers, []string{"d:5555"}) || cfg.Manager.Intervals.UpdateTasks != time.Minute || cfg.Worker.OrphanPolicy != "keep" {
+		t.Errorf("configuration from flags is %+v", cfg)
+	}
+	if cfg.Manager.Listen != "0.0.0.0:7000" || cfg.DataDir != "/var/lib/cube" {
+		t.Errorf("configuration from file was lost: %+v", cfg)
+	}
+
+	// -config overrides CUBE_CONFIG.
+	other := writeConfig(t, "manager:\n  listen: 0.0.0.0:8000\n")
+	if cfg, err = loadConfig("cube", []string{"-config", other}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Manager.Listen != "0.0.0.0:8000" {
+		t.Errorf("manager listens on %q, want the address from the -config file", cfg.Manager.Listen)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		env    map[string]string
+		args   []string
+		err    string
+	}{
+		{"negative parallelism in file", "manager:\n  parallelism: -1\n", nil, nil, "parallelism must not be negative"},
+		{"negative parallelism in environment", "", map[string]string{"CUBE_WORKER_PARALLELISM": "-2"}, nil, "parallelism must not be negative"},
+		{"negative parallelism in flags", "", nil, []string{"-manager-parallelism", "-3"}, "parallelism must not be negative"},
+		{"invalid parallelism in environment", "", map[string]string{"CUBE_MANAGER_PARALLELISM": "many"}, nil, `invalid CUBE_MANAGER_PARALLELISM "many"`},
+		{"invalid disable exec", "", map[string]string{"CUBE_DISABLE_EXEC": "sometimes"}, nil, `invalid CUBE_DISABLE_EXEC "sometimes"`},
+		{"unknown orphan policy", "worker:\n  orphanPolicy: delete\n", nil, nil, `unknown orphan policy "delete"`},
+		{"unknown orphan policy in flags", "", nil, []string{"-orphan-policy", "adopt"}, `unknown orphan policy "adopt"`},
+		{"invalid yaml", "manager: [\n", nil, nil, "reading config"},
+		{"invalid duration", "manager:\n  retryDelay: soon\n", nil, nil, "reading config"},
+		{"arguments", "", nil, []string{"manager"}, "unexpected arguments: manager"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for name, v := range tt.env {
+				t.Setenv(name, v)
+			}
+			args := tt.args
+			if tt.config != "" {
+				args = append([]string{"-config", writeConfig(t, tt.config)}, args...)
+			}
+			_, err := loadConfig("cube", args)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+
+	clearEnv(t)
+	if _, err := loadConfig("cube", []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}); err == nil {
+		t.Error("loading a missing config file succeeded")
+	}
+}
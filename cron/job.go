@@ -0,0 +1,102 @@
+package cron
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/araminian/cube/task"
+	"github.com/google/uuid"
+)
+
+type ConcurrencyPolicy string
+
+const (
+	// Allow starts a new task at every fire time, even when earlier ones
+	// are still running.
+	Allow ConcurrencyPolicy = "Allow"
+	// Forbid skips a fire time while an earlier task is still running.
+	Forbid ConcurrencyPolicy = "Forbid"
+	// Replace stops the tasks still running from earlier fire times and
+	// starts a new one.
+	Replace ConcurrencyPolicy = "Replace"
+)
+
+// Job starts a new task from Task at every time Schedule fires at. The
+// tasks run as jobs, i.e. they are expected to exit.
+type Job struct {
+	ID       uuid.UUID
+	Name     string
+	Schedule string
+	// TimeZone is the IANA time zone Schedule is evaluated in. Empty uses
+	// the manager's local time.
+	TimeZone          string
+	Task              task.Task
+	ConcurrencyPolicy ConcurrencyPolicy
+	// StartingDeadlineSeconds is how late a task may still be started for
+	// a fire time that was missed, e.g. while the manager was down. Zero
+	// starts one task for the latest missed fire time however late it is.
+	StartingDeadlineSeconds int
+	// SuccessfulHistoryLimit and FailedHistoryLimit are how many finished
+	// tasks of the job are kept. Zero keeps 3 completed and 1 failed task,
+	// a negative value keeps none.
+	SuccessfulHistoryLimit int
+	FailedHistoryLimit     int
+	Suspend                bool
+
+	CreateTime       time.Time
+	LastScheduleTime time.Time
+	// Tasks are the tasks started for the job that were not cleaned up
+	// yet, oldest first.
+	Tasks []uuid.UUID
+}
+
+// Validate checks that the job can be scheduled.
+func (j *Job) Validate() error {
+	if j.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if j.Task.Image == "" {
+		return fmt.Errorf("task image is required")
+	}
+	if err := j.Task.Validate(); err != nil {
+		return err
+	}
+	if _, err := j.Location(); err != nil {
+		return err
+	}
+	if _, err := Parse(j.Schedule); err != nil {
+		return err
+	}
+	switch j.ConcurrencyPolicy {
+	case "", Allow, Forbid, Replace:
+	default:
+		return fmt.Errorf("unknown concurrency policy %q", j.ConcurrencyPolicy)
+	}
+	if j.StartingDeadlineSeconds < 0 {
+		return fmt.Errorf("starting deadline must not be negative")
+	}
+	return nil
+}
+
+// Location returns the time zone the schedule of the job is evaluated in.
+func (j *Job) Location() (*time.Location, error) {
+	if j.TimeZone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(j.TimeZone)
+}
+
+func (j *Job) SuccessfulLimit() int {
+	return historyLimit(j.SuccessfulHistoryLimit, 3)
+}
+
+func (j *Job) FailedLimit() int {
+	return historyLimit(j.FailedHistoryLimit, 1)
+}
+
+func historyLimit(limit int, def int) int {
+	if limit == 0 {
+		return def
+	}
+	return max(limit, 0)
+}
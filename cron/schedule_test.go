@@ -0,0 +1,188 @@
+package cron
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expr, same string
+	}{
+		{"0-59 0-23 * 1-12 *", "* * * * *"},
+		{"*/15 * * * *", "0,15,30,45 * * * *"},
+		{"10-30/10 * * * *", "10,20,30 * * * *"},
+		{"50/5 * * * *", "50,55 * * * *"},
+		{"0 0 * jan-mar MON,fri", "0 0 * 1-3 1,5"},
+		{"0 0 * * 7", "0 0 * * 0"},
+		{"0 0 * * 5-7", "0 0 * * 0,5,6"},
+		{"@yearly", "0 0 1 1 *"},
+		{"@annually", "0 0 1 1 *"},
+		{"@monthly", "0 0 1 * *"},
+		{"@weekly", "0 0 * * 0"},
+		{"@daily", "0 0 * * *"},
+		{"@midnight", "0 0 * * *"},
+		{" @HOURLY ", "0 * * * *"},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("parsing %q: %v", tt.expr, err)
+			continue
+		}
+		want, err := Parse(tt.same)
+		if err != nil {
+			t.Fatalf("parsing %q: %v", tt.same, err)
+		}
+		if *got != *want {
+			t.Errorf("%q parsed to %+v, want %+v like %q", tt.expr, *got, *want, tt.same)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expr, err string
+	}{
+		{"", "has 0 fields"},
+		{"* * * *", "has 4 fields"},
+		{"* * * * * *", "has 6 fields"},
+		{"@reboot", "has 1 fields"},
+		{"60 * * * *", "minute: value 60 out of range"},
+		{"* 24 * * *", "hour: value 24 out of range"},
+		{"* * 0 * *", "day of month: value 0 out of range"},
+		{"* * * 13 *", "month: value 13 out of range"},
+		{"* * * * 8", "day of week: value 8 out of range"},
+		{"30-10 * * * *", `range "30-10" is backwards`},
+		{"*/0 * * * *", `invalid step "0"`},
+		{"*/x * * * *", `invalid step "x"`},
+		{"a * * * *", `invalid value "a"`},
+		{"* * * * monday", `invalid value "monday"`},
+		{"* * * jan-foo *", `invalid value "foo"`},
+		{"1,,2 * * * *", `invalid value ""`},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parsing %q: got error %v, want one containing %q", tt.expr, err, tt.err)
+		}
+	}
+}
+
+const layout = "2006-01-02 15:04 MST"
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		expr, from, want string
+	}{
+		{"* * * * *", "2026-10-18 10:07 UTC", "2026-10-18 10:08 UTC"},
+		{"*/15 * * * *", "2026-10-18 10:07 UTC", "2026-10-18 10:15 UTC"},
+		{"*/15 * * * *", "2026-10-18 10:15 UTC", "2026-10-18 10:30 UTC"},
+		{"0 9-17/4 * * *", "2026-10-18 10:00 UTC", "2026-10-18 13:00 UTC"},
+		{"@hourly", "2026-10-18 23:30 UTC", "2026-10-19 00:00 UTC"},
+		// Month and year ends.
+		{"0 0 31 * *", "2026-04-01 00:00 UTC", "2026-05-31 00:00 UTC"},
+		{"0 0 1 * *", "2026-12-15 08:00 UTC", "2027-01-01 00:00 UTC"},
+		{"59 23 31 12 *", "2026-06-01 00:00 UTC", "2026-12-31 23:59 UTC"},
+		{"59 23 31 12 *", "2026-12-31 23:59 UTC", "2027-12-31 23:59 UTC"},
+		{"0 0 29 2 *", "2026-03-01 00:00 UTC", "2028-02-29 00:00 UTC"},
+		// Days of the week, Sunday as both 0 and 7.
+		{"0 12 * * mon-fri", "2026-10-17 13:00 UTC", "2026-10-19 12:00 UTC"},
+		{"0 0 * * 7", "2026-10-19 00:00 UTC", "2026-10-25 00:00 UTC"},
+		{"0 0 * * sun", "2026-10-19 00:00 UTC", "2026-10-25 00:00 UTC"},
+		// A day matches either day field unless one of them is "*".
+		{"0 0 1,15 * mon", "2026-10-19 00:00 UTC", "2026-10-26 00:00 UTC"},
+		{"0 0 1,15 * mon", "2026-10-28 00:00 UTC", "2026-11-01 00:00 UTC"},
+		{"0 0 13 * fri", "2026-10-19 00:00 UTC", "2026-10-23 00:00 UTC"},
+		{"0 0 */2 * mon", "2026-10-19 00:00 UTC", "2026-11-09 00:00 UTC"},
+		{"0 0 13 * *", "2026-10-19 00:00 UTC", "2026-11-13 00:00 UTC"},
+		// Never fires.
+		{"0 0 30 2 *", "2026-10-18 00:00 UTC", ""},
+		{"0 0 31 4,6,9,11 *", "2026-10-18 00:00 UTC", ""},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("parsing %q: %v", tt.expr, err)
+		}
+		from, err := time.Parse(layout, tt.from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := s.Next(from)
+		if tt.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%q after %s: got %s, want none", tt.expr, tt.from, got.Format(layout))
+			}
+			continue
+		}
+		if got.Format(layout) != tt.want {
+			t.Errorf("%q after %s: got %s, want %s", tt.expr, tt.from, got.Format(layout), tt.want)
+		}
+	}
+}
+
+func TestNextDaylightSaving(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		expr string
+		from time.Time
+		want string
+	}{
+		// 2:00 to 2:59 don't exist on March 8th 2026.
+		{"30 2 * * *", time.Date(2026, 3, 7, 12, 0, 0, 0, ny), "2026-03-09 02:30 EDT"},
+		{"0 * * * *", time.Date(2026, 3, 8, 1, 10, 0, 0, ny), "2026-03-08 03:00 EDT"},
+		{"30 3 * * *", time.Date(2026, 3, 8, 0, 0, 0, 0, ny), "2026-03-08 03:30 EDT"},
+		// 1:00 to 1:59 happen twice on November 1st 2026.
+		{"30 1 * * *", time.Date(2026, 10, 31, 12, 0, 0, 0, ny), "2026-11-01 01:30 EDT"},
+		{"0 3 * * *", time.Date(2026, 11, 1, 0, 0, 0, 0, ny), "2026-11-01 03:00 EST"},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("parsing %q: %v", tt.expr, err)
+		}
+		if got := s.Next(tt.from); got.Format(layout) != tt.want {
+			t.Errorf("%q after %s: got %s, want %s", tt.expr, tt.from.Format(layout), got.Format(layout), tt.want)
+		}
+	}
+}
+
+func TestLatest(t *testing.T) {
+	now := time.Date(2026, 10, 18, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		expr  string
+		after time.Time
+		want  string
+	}{
+		{"* * * * *", now.Add(-time.Minute), "2026-10-18 10:07 UTC"},
+		{"* * * * *", now.AddDate(-3, 0, 0), "2026-10-18 10:07 UTC"},
+		{"0 0 1 1 *", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), "2026-01-01 00:00 UTC"},
+		{"0 0 29 2 *", time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), "2024-02-29 00:00 UTC"},
+		{"*/5 * * * *", time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC), "2026-10-18 10:05 UTC"},
+		// Nothing since after.
+		{"*/5 * * * *", time.Date(2026, 10, 18, 10, 5, 0, 0, time.UTC), ""},
+		{"0 0 1 1 *", time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC), ""},
+		{"0 0 30 2 *", time.Time{}, ""},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("parsing %q: %v", tt.expr, err)
+		}
+		got := s.Latest(tt.after, now)
+		if tt.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%q since %v: got %s, want none", tt.expr, tt.after, got.Format(layout))
+			}
+			continue
+		}
+		if got.Format(layout) != tt.want {
+			t.Errorf("%q since %v: got %s, want %s", tt.expr, tt.after, got.Format(layout), tt.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package dispatch
+
+import (
+	"sync"
+	"time"
+)
+
+// Dispatcher runs queued items on a bounded number of goroutines as soon as
+// they are added. Items with the same key are never handled concurrently
+// and are handled in the order they were added. The zero value is not
+// usable; create one with New.
+type Dispatcher[T any] struct {
+	key    func(T) string
+	handle func(T)
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// items holds the queued items of every key, ready the keys that have
+	// queued items and no item being handled.
+	items map[string][]T
+	ready []string
+	busy  map[string]bool
+	len   int
+}
+
+// New creates a dispatcher that passes every item to handle. key returns
+// the key items are serialized by.
+func New[T any](key func(T) string, handle func(T)) *Dispatcher[T] {
+	d := &Dispatcher[T]{
+		key:    key,
+		handle: handle,
+		items:  make(map[string][]T),
+		busy:   make(map[string]bool),
+	}
+	d.cond = sync.NewCond(&d.mu)
+	return d
+}
+
+// Add queues item to be handled.
+func (d *Dispatcher[T]) Add(item T) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	k := d.key(item)
+	if len(d.items[k]) == 0 && !d.busy[k] {
+		d.ready = append(d.ready, k)
+	}
+	d.items[k] = append(d.items[k], item)
+	d.len++
+	d.cond.Signal()
+}
+
+// AddAfter queues item to be handled once delay has passed.
+func (d *Dispatcher[T]) AddAfter(item T, delay time.Duration) {
+	time.AfterFunc(delay, func() { d.Add(item) })
+}
+
+// Len returns the number of items waiting to be handled.
+func (d *Dispatcher[T]) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.len
+}
+
+// Run handles queued items on parallelism goroutines. It never returns.
+func (d *Dispatcher[T]) Run(parallelism int) {
+	for i := 1; i < parallelism; i++ {
+		go d.execute()
+	}
+	d.execute()
+}
+
+func (d *Dispatcher[T]) execute() {
+	for {
+		d.mu.Lock()
+		for len(d.ready) == 0 {
+			d.cond.Wait()
+		}
+		k := d.ready[0]
+		d.ready = d.ready[1:]
+		item := d.items[k][0]
+		d.items[k] = d.items[k][1:]
+		d.len--
+		d.busy[k] = true
+		d.mu.Unlock()
+
+		d.handle(item)
+
+		d.mu.Lock()
+		delete(d.busy, k)
+		if len(d.items[k]) > 0 {
+			d.ready = append(d.ready, k)
+			d.cond.Signal()
+		} else {
+			delete(d.items, k)
+		}
+		d.mu.Unlock()
+	}
+}
@@ -3,62 +3,240 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"time"
 
+	"github.com/araminian/cube/cron"
 	"github.com/araminian/cube/manager"
+	"github.com/araminian/cube/service"
+	"github.com/araminian/cube/store"
 	"github.com/araminian/cube/task"
 	"github.com/araminian/cube/worker"
-	"github.com/golang-collections/collections/queue"
-	"github.com/google/uuid"
+	"github.com/araminian/cube/workflow"
 )
 
+const usage = `Usage: %s [ROLE] [flags]
+
+Roles:
+  standalone  Run a manager and a worker that joins it (default)
+  manager     Run a manager only
+  worker      Run a worker only
+
+Run it with ROLE -h for the flags. Flags override CUBE_* environment
+variables, which override the file given with -config or CUBE_CONFIG.
+`
+
 func main() {
+	role := "standalone"
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		role, args = args[0], args[1:]
+	}
+	if role != "standalone" && role != "manager" && role != "worker" {
+		fmt.Fprintf(os.Stderr, usage, filepath.Base(os.Args[0]))
+		os.Exit(2)
+	}
 
-	db := make(map[uuid.UUID]*task.Task)
-	w := worker.Worker{
-		Name:  "worker1",
-		Queue: *queue.New(),
-		Db:    db,
+	cfg, err := loadConfig(role, args)
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
 	}
 
-	whost := "localhost"
-	wport := 5555
+	if role != "worker" {
+		err := startManager(cfg)
+		if err != nil {
+			log.Fatalf("Error starting manager: %v", err)
+		}
+	}
+	if role != "manager" {
+		if role == "standalone" && cfg.Worker.Join == "" {
+			cfg.Worker.Join, err = reachableURL(cfg.Manager.Listen)
+			if err != nil {
+				log.Fatalf("Error starting worker: %v", err)
+			}
+		}
+		err := startWorker(cfg)
+		if err != nil {
+			log.Fatalf("Error starting worker: %v", err)
+		}
+	}
+	select {}
+}
 
-	mhost := "localhost"
-	mport := 5556
+// startWorker starts the worker API and loops and, when cfg names a manager
+// to join, sends it heartbeats.
+func startWorker(cfg Config) error {
+	c := cfg.Worker
+	rt, err := newRuntime(c.Runtime)
+	if err != nil {
+		return fmt.Errorf("creating runtime: %v", err)
+	}
+	host, port, err := splitListen(c.Listen)
+	if err != nil {
+		return err
+	}
+	advertise := c.Advertise
+	if advertise == "" {
+		advertise, err = reachableURL(c.Listen)
+		if err != nil {
+			return err
+		}
+	}
+	name := c.Name
+	if name == "" {
+		name, err = os.Hostname()
+		if err != nil {
+			return err
+		}
+	}
+
+	wdb, err := newStore[task.Task](cfg.DataDir, "worker-tasks.jsonl")
+	if err != nil {
+		return fmt.Errorf("opening worker task store: %v", err)
+	}
+	w, err := worker.NewWorker(name, wdb, rt)
+	if err != nil {
+		return err
+	}
+	w.OrphanPolicy = worker.OrphanPolicy(c.OrphanPolicy)
+	w.DisableExec = cfg.DisableExec
+	if c.Parallelism > 0 {
+		w.Parallelism = c.Parallelism
+	}
+	setDuration(&w.StatsInterval, c.Intervals.Stats)
+	setDuration(&w.InspectInterval, c.Intervals.Inspect)
+	err = w.Reconcile()
+	if err != nil {
+		log.Printf("Error reconciling worker %s with its containers: %v", w.Name, err)
+	}
 
 	wapi := worker.API{
-		Worker:  &w,
-		Address: whost,
-		Port:    wport,
+		Worker:  w,
+		Address: host,
+		Port:    port,
 	}
 
 	go w.RunTask()
 	go w.CollectStats()
+	go w.RunHealthChecks()
+	go w.ObserveTasks()
 	go wapi.Start()
+	if c.Join != "" {
+		go w.SendHeartbeats(c.Join, advertise, c.Intervals.Heartbeat)
+	} else {
+		log.Printf("Worker %s joins no manager, it must be listed in the workers of one", w.Name)
+	}
+	return nil
+}
+
+// startManager starts the manager API and loops.
+func startManager(cfg Config) error {
+	c := cfg.Manager
+	host, port, err := splitListen(c.Listen)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Manager: Starting with workers %v", c.Workers)
+	mtdb, err := newStore[task.Task](cfg.DataDir, "manager-tasks.jsonl")
+	if err != nil {
+		return fmt.Errorf("opening manager task store: %v", err)
+	}
+	medb, err := newStore[task.TaskEvent](cfg.DataDir, "manager-events.jsonl")
+	if err != nil {
+		return fmt.Errorf("opening manager event store: %v", err)
+	}
+	m, err := manager.NewManager(c.Workers, c.Scheduler, mtdb, medb)
+	if err != nil {
+		return err
+	}
+	m.CronDb, err = newStore[cron.Job](cfg.DataDir, "manager-cronjobs.jsonl")
+	if err != nil {
+		return fmt.Errorf("opening manager cron job store: %v", err)
+	}
+	m.WorkflowDb, err = newStore[workflow.Workflow](cfg.DataDir, "manager-workflows.jsonl")
+	if err != nil {
+		return fmt.Errorf("opening manager workflow store: %v", err)
+	}
+	m.ServiceDb, err = newStore[service.Service](cfg.DataDir, "manager-services.jsonl")
+	if err != nil {
+		return fmt.Errorf("opening manager service store: %v", err)
+	}
+	m.DisableExec = cfg.DisableExec
+	if c.Parallelism > 0 {
+		m.Parallelism = c.Parallelism
+	}
+	if cfg.DataDir != "" {
+		audit, err := os.OpenFile(filepath.Join(cfg.DataDir, "manager-exec-audit.jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("opening manager exec audit log: %v", err)
+		}
+		m.ExecAudit = audit
+	}
+	setDuration(&m.HeartbeatTimeout, c.HeartbeatTimeout)
+	setDuration(&m.LostTaskGracePeriod, c.LostTaskGracePeriod)
+	setDuration(&m.RetryDelay, c.RetryDelay)
+	setDuration(&m.UpdateInterval, c.Intervals.UpdateTasks)
+	setDuration(&m.NodeStatsInterval, c.Intervals.NodeStats)
+	setDuration(&m.NodeCheckInterval, c.Intervals.CheckNodes)
+	setDuration(&m.RestartInterval, c.Intervals.RestartTasks)
+	setDuration(&m.ServiceInterval, c.Intervals.Services)
+	setDuration(&m.CronInterval, c.Intervals.CronJobs)
+	setDuration(&m.WorkflowInterval, c.Intervals.Workflows)
 
-	workers := []string{fmt.Sprintf("%s:%d", whost, wport)}
-	fmt.Printf("Manager : Starting with workers %v\n", workers)
-	m := manager.NewManager(workers)
 	mapi := manager.Api{
 		Manager: m,
-		Address: mhost,
-		Port:    mport,
+		Address: host,
+		Port:    port,
 	}
 
 	go m.ProcessTasks()
 	go m.UpdateTasks()
+	go m.UpdateNodeStats()
+	go m.CheckNodes()
+	go m.RestartTasks()
+	go m.RunCronJobs()
+	go m.RunWorkflows()
+	go m.ReconcileServices()
 	go mapi.Start()
+	return nil
+}
 
-	for {
-		log.Printf("Manager: TaskDB: %v\n", m.TaskDb)
-		for _, t := range m.TaskDb {
-			fmt.Printf("Manager Task : id: %s , state: %d\n", t.ID, t.State)
-		}
-		time.Sleep(10 * time.Second)
+// setDuration sets *d to v unless v is zero, which keeps the default.
+func setDuration(d *time.Duration, v time.Duration) {
+	if v != 0 {
+		*d = v
 	}
 }
 
+// newStore keeps values in memory, or in a journal file in dataDir when one
+// is given.
+func newStore[T any](dataDir string, name string) (store.Store[T], error) {
+	if dataDir == "" {
+		return store.NewMemoryStore[T](), nil
+	}
+	return store.NewJournalStore[T](filepath.Join(dataDir, name))
+}
+
+func newRuntime(name string) (task.Runtime, error) {
+	switch name {
+	case "", "docker":
+		return task.NewDocker()
+	case "fake":
+		return task.NewFakeRuntime(), nil
+	case "process":
+		return task.NewProcessRuntime(filepath.Join(os.TempDir(), "cube", "logs"))
+	default:
+		return nil, fmt.Errorf("unknown runtime %q", name)
+	}
+}
+
+// go run . standalone -runtime fake -data-dir /tmp/cube
+// go run . manager -manager-listen 0.0.0.0:5556 -scheduler epvm
+// go run . worker -worker-listen 0.0.0.0:5555 -advertise http://10.0.0.11:5555 -join http://10.0.0.10:5556
+// go run . manager -config cube.yaml
+
 // Worker
 // curl -X POST http://localhost:5555/tasks -d '{"ID":"123e4567-e89b-12d3-a456-426614174000","State":2,"TASK":{"ID":"123e4567-e89b-12d3-a456-426614174000","State":1,"Name":"test","Image":"nginx:latest"}}'
 // curl localhost:5555/tasks
@@ -66,5 +244,17 @@ func main() {
 
 // Manager
 // curl -X POST http://localhost:5556/tasks -d '{"ID":"123e4567-e89b-12d3-a456-426614174000","State":2,"TASK":{"ID":"123e4567-e89b-12d3-a456-426614174000","State":1,"Name":"test","Image":"nginx:latest"}}'
+// curl -X POST http://localhost:5556/tasks -d '{"ID":"123e4567-e89b-12d3-a456-426614174001","State":1,"Task":{"ID":"123e4567-e89b-12d3-a456-426614174001","State":1,"Name":"web","Image":"nginx:latest","PortBindings":{"80/tcp":"8080"}}}'
+// curl -X POST http://localhost:5556/tasks -d '{"ID":"123e4567-e89b-12d3-a456-426614174002","State":1,"Task":{"ID":"123e4567-e89b-12d3-a456-426614174002","State":1,"Name":"db","Image":"postgres:16","Env":["POSTGRES_PASSWORD=secret"],"Cpu":1.5,"Mounts":[{"Type":"volume","Source":"pgdata","Target":"/var/lib/postgresql/data"}]}}'
 // curl localhost:5556/tasks
 // curl -X DELETE localhost:5556/tasks/123e4567-e89b-12d3-a456-426614174000
+// curl "localhost:5556/tasks/123e4567-e89b-12d3-a456-426614174000/logs?tail=100&since=10m&timestamps=true&follow=true"
+// websocat "ws://localhost:5556/tasks/123e4567-e89b-12d3-a456-426614174000/exec?cmd=sh&tty=true&stdin=true"
+// curl -X POST http://localhost:5556/cronjobs -d '{"Name":"export","Schedule":"0 2 * * *","ConcurrencyPolicy":"Forbid","Task":{"Image":"alpine","Cmd":["echo","export"]}}'
+// curl localhost:5556/cronjobs
+// curl -X POST http://localhost:5556/workflows -d '{"Name":"release","Tasks":[{"Name":"build","Image":"alpine"},{"Name":"test","Image":"alpine","DependsOn":["build"]},{"Name":"publish","Image":"alpine","DependsOn":["test"]}]}'
+// curl localhost:5556/workflows
+// curl -X POST http://localhost:5556/services -d '{"Name":"web","Replicas":3,"Task":{"Image":"nginx:latest"}}'
+// curl -X PATCH http://localhost:5556/services/<id> -d '{"Replicas":5}'
+// curl -X PATCH http://localhost:5556/services/<id> -d '{"Task":{"Image":"nginx:1.27"},"UpdateConfig":{"MaxSurge":1,"MaxUnavailable":1}}'
+// curl -X POST http://localhost:5556/services/<id>/rollback -d '{"Revision":1}'
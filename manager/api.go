@@ -21,7 +21,46 @@ func (a *Api) initRouter() {
 		r.Post("/", a.StartTaskHandler)
 		r.Get("/", a.GetTasksHandler)
 		r.Route("/{taskID}", func(r chi.Router) {
+			r.Get("/", a.GetTaskHandler)
 			r.Delete("/", a.StopTaskHandler)
+			r.Get("/logs", a.GetTaskLogsHandler)
+			r.Get("/exec", a.ExecTaskHandler)
+		})
+	})
+	a.Router.Get("/events", a.GetEventsHandler)
+	a.Router.Route("/nodes", func(r chi.Router) {
+		r.Post("/", a.RegisterNodeHandler)
+		r.Get("/", a.GetNodesHandler)
+		r.Route("/{nodeName}", func(r chi.Router) {
+			r.Get("/", a.GetNodeHandler)
+			r.Delete("/", a.DeregisterNodeHandler)
+			r.Post("/heartbeat", a.HeartbeatHandler)
+		})
+	})
+	a.Router.Route("/cronjobs", func(r chi.Router) {
+		r.Post("/", a.CreateCronJobHandler)
+		r.Get("/", a.GetCronJobsHandler)
+		r.Route("/{cronJobID}", func(r chi.Router) {
+			r.Get("/", a.GetCronJobHandler)
+			r.Delete("/", a.DeleteCronJobHandler)
+		})
+	})
+	a.Router.Route("/workflows", func(r chi.Router) {
+		r.Post("/", a.CreateWorkflowHandler)
+		r.Get("/", a.GetWorkflowsHandler)
+		r.Route("/{workflowID}", func(r chi.Router) {
+			r.Get("/", a.GetWorkflowHandler)
+			r.Delete("/", a.CancelWorkflowHandler)
+		})
+	})
+	a.Router.Route("/services", func(r chi.Router) {
+		r.Post("/", a.CreateServiceHandler)
+		r.Get("/", a.GetServicesHandler)
+		r.Route("/{serviceID}", func(r chi.Router) {
+			r.Get("/", a.GetServiceHandler)
+			r.Patch("/", a.UpdateServiceHandler)
+			r.Post("/rollback", a.RollbackServiceHandler)
+			r.Delete("/", a.DeleteServiceHandler)
 		})
 	})
 }
@@ -0,0 +1,233 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/araminian/cube/cron"
+	"github.com/araminian/cube/task"
+	"github.com/google/uuid"
+)
+
+// CreateCronJob validates j and adds it to the manager. The job first fires
+// at the first fire time after it was created.
+func (m *Manager) CreateCronJob(j cron.Job) (cron.Job, error) {
+	err := j.Validate()
+	if err != nil {
+		return cron.Job{}, err
+	}
+	if j.ConcurrencyPolicy == "" {
+		j.ConcurrencyPolicy = cron.Allow
+	}
+	if j.ID == uuid.Nil {
+		j.ID = uuid.New()
+	}
+	j.CreateTime = time.Now()
+	j.LastScheduleTime = j.CreateTime
+	j.Tasks = nil
+
+	m.cronMu.Lock()
+	defer m.cronMu.Unlock()
+	if _, err := m.CronDb.Get(j.ID.String()); err == nil {
+		return cron.Job{}, fmt.Errorf("cron job %s already exists", j.ID)
+	}
+	err = m.CronDb.Put(j.ID.String(), j)
+	if err != nil {
+		return cron.Job{}, err
+	}
+	log.Printf("Manager: Created cron job %s (%s) with schedule %q", j.Name, j.ID, j.Schedule)
+	return j, nil
+}
+
+// DeleteCronJob removes the cron job with the given id. Tasks it already
+// started are left alone. It reports false when there is no such job.
+func (m *Manager) DeleteCronJob(id uuid.UUID) bool {
+	m.cronMu.Lock()
+	defer m.cronMu.Unlock()
+	if _, err := m.CronDb.Get(id.String()); err != nil {
+		return false
+	}
+	err := m.CronDb.Delete(id.String())
+	if err != nil {
+		log.Printf("Manager: Error deleting cron job %s: %v", id, err)
+		return false
+	}
+	log.Printf("Manager: Deleted cron job %s", id)
+	return true
+}
+
+func (m *Manager) GetCronJob(id uuid.UUID) (cron.Job, bool) {
+	j, err := m.CronDb.Get(id.String())
+	return j, err == nil
+}
+
+func (m *Manager) GetCronJobs() []cron.Job {
+	jobs, err := m.CronDb.List()
+	if err != nil {
+		log.Printf("Manager: Error listing cron jobs: %v", err)
+		return []cron.Job{}
+	}
+	return jobs
+}
+
+func (m *Manager) RunCronJobs() {
+	for {
+		m.runCronJobs(time.Now())
+		time.Sleep(m.CronInterval)
+	}
+}
+
+func (m *Manager) runCronJobs(now time.Time) {
+	m.cronMu.Lock()
+	defer m.cronMu.Unlock()
+
+	jobs, err := m.CronDb.List()
+	if err != nil {
+		log.Printf("Manager: Error listing cron jobs: %v", err)
+		return
+	}
+	for _, j := range jobs {
+		if !m.runCronJob(&j, now) {
+			continue
+		}
+		err := m.CronDb.Put(j.ID.String(), j)
+		if err != nil {
+			log.Printf("Manager: Error saving cron job %s: %v", j.ID, err)
+		}
+	}
+}
+
+// runCronJob cleans up the finished tasks of j beyond its history limits
+// and starts a task for j when it is due. It reports whether j changed.
+func (m *Manager) runCronJob(j *cron.Job, now time.Time) bool {
+	changed := m.cleanupCronTasks(j)
+	if j.Suspend {
+		return changed
+	}
+
+	schedule, err := cron.Parse(j.Schedule)
+	if err != nil {
+		log.Printf("Manager: Invalid schedule of cron job %s: %v", j.Name, err)
+		return changed
+	}
+	loc, err := j.Location()
+	if err != nil {
+		log.Printf("Manager: Invalid time zone of cron job %s: %v", j.Name, err)
+		return changed
+	}
+
+	// Fire times missed while the manager was down are coalesced into a
+	// single run for the latest of them.
+	last := j.LastScheduleTime.In(loc)
+	fire := schedule.Latest(last, now.In(loc))
+	if fire.IsZero() {
+		return changed
+	}
+	j.LastScheduleTime = fire
+	if next := schedule.Next(last); next.Before(fire) {
+		log.Printf("Manager: Cron job %s missed its fire times since %v, running it for the one at %v", j.Name, next, fire)
+	}
+
+	deadline := time.Duration(j.StartingDeadlineSeconds) * time.Second
+	if deadline > 0 && now.Sub(fire) > deadline {
+		log.Printf("Manager: Cron job %s missed its starting deadline for %v, skipping it", j.Name, fire)
+		return true
+	}
+
+	active := m.activeCronTasks(j)
+	switch j.ConcurrencyPolicy {
+	case cron.Forbid:
+		if len(active) > 0 {
+			log.Printf("Manager: Cron job %s still has %d running tasks, skipping run at %v", j.Name, len(active), fire)
+			return true
+		}
+	case cron.Replace:
+		for _, t := range active {
+			log.Printf("Manager: Cron job %s replaces task %s", j.Name, t.ID)
+			m.StopTask(t)
+		}
+	}
+
+	t := j.Task
+	t.ID = uuid.New()
+	t.Name = fmt.Sprintf("%s-%d", j.Name, fire.Unix())
+	t.State = task.Scheduled
+	t.Mode = task.ModeJob
+	log.Printf("Manager: Cron job %s starts task %s for %v", j.Name, t.ID, fire)
+	m.SubmitTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Scheduled,
+		Timestamp: now,
+		Task:      t,
+	})
+	j.Tasks = append(j.Tasks, t.ID)
+	return true
+}
+
+// activeCronTasks returns the tasks of j that have not finished yet.
+func (m *Manager) activeCronTasks(j *cron.Job) []task.Task {
+	var active []task.Task
+	for _, id := range j.Tasks {
+		t, ok := m.GetTask(id)
+		if ok && !m.isFinished(t) {
+			active = append(active, t)
+		}
+	}
+	return active
+}
+
+// cleanupCronTasks deletes the oldest finished tasks of j beyond its
+// history limits. It reports whether j changed.
+func (m *Manager) cleanupCronTasks(j *cron.Job) bool {
+	var kept, completed, failed []uuid.UUID
+	for _, id := range j.Tasks {
+		t, ok := m.GetTask(id)
+		switch {
+		case !ok:
+			continue
+		case t.State == task.Completed:
+			completed = append(completed, id)
+		case m.isFinished(t):
+			failed = append(failed, id)
+		}
+		kept = append(kept, id)
+	}
+
+	var expired []uuid.UUID
+	if n := len(completed) - j.SuccessfulLimit(); n > 0 {
+		expired = append(expired, completed[:n]...)
+	}
+	if n := len(failed) - j.FailedLimit(); n > 0 {
+		expired = append(expired, failed[:n]...)
+	}
+	if len(expired) == 0 && len(kept) == len(j.Tasks) {
+		return false
+	}
+
+	m.mu.Lock()
+	for _, id := range expired {
+		t, err := m.TaskDb.Get(id.String())
+		if err != nil || !m.isFinished(t) {
+			continue
+		}
+		if worker, ok := m.TaskWorkerMap[id]; ok {
+			m.unassignTask(t, worker)
+		}
+		err = m.TaskDb.Delete(id.String())
+		if err != nil {
+			log.Printf("Manager: Error deleting task %s of cron job %s: %v", id, j.Name, err)
+			continue
+		}
+		log.Printf("Manager: Deleted task %s of cron job %s beyond its history limit", id, j.Name)
+	}
+	m.mu.Unlock()
+
+	j.Tasks = j.Tasks[:0]
+	for _, id := range kept {
+		if _, ok := m.GetTask(id); ok {
+			j.Tasks = append(j.Tasks, id)
+		}
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/araminian/cube/node"
+	"github.com/araminian/cube/task"
+	"github.com/araminian/cube/worker"
+	"github.com/google/uuid"
+	"golang.org/x/net/websocket"
+)
+
+var (
+	errExecDisabled   = errors.New("exec is disabled")
+	errTaskNotRunning = errors.New("task is not running")
+)
+
+// ExecRecord is the audit record of an exec session. Every session gets a
+// start and an end record, requests that are turned down a denied record.
+type ExecRecord struct {
+	Time       time.Time
+	Event      string
+	Task       uuid.UUID
+	Worker     string
+	Cmd        []string
+	Tty        bool
+	Stdin      bool
+	RemoteAddr string
+	ExitCode   int
+	Error      string
+	Duration   time.Duration
+}
+
+// DialExec opens an exec session on the worker running the task with the
+// given id, with query as the query string of the request.
+func (m *Manager) DialExec(id uuid.UUID, query string) (*websocket.Conn, node.Node, error) {
+	if m.DisableExec {
+		return nil, node.Node{}, errExecDisabled
+	}
+	if t, ok := m.GetTask(id); ok && t.State != task.Running {
+		return nil, node.Node{}, errTaskNotRunning
+	}
+	n, err := m.taskNode(id)
+	if err != nil {
+		return nil, node.Node{}, err
+	}
+
+	u, err := url.Parse(n.Api)
+	if err != nil {
+		return nil, node.Node{}, err
+	}
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	u.Path = fmt.Sprintf("/tasks/%s/exec", id)
+	u.RawQuery = query
+	ws, err := websocket.Dial(u.String(), "", n.Api)
+	if err != nil {
+		return nil, node.Node{}, fmt.Errorf("worker %s refused exec session: %v", n.Name, err)
+	}
+	return ws, n, nil
+}
+
+// ProxyExec passes the messages of an exec session between client and the
+// worker connection wc until either side goes away, then audits the end of
+// the session described by rec.
+func (m *Manager) ProxyExec(client, wc *websocket.Conn, rec ExecRecord) {
+	start := time.Now()
+	go func() {
+		for {
+			var msg string
+			if websocket.Message.Receive(client, &msg) != nil {
+				wc.Close()
+				return
+			}
+			if websocket.Message.Send(wc, msg) != nil {
+				return
+			}
+		}
+	}()
+
+	rec.Error = "session ended before the command exited"
+	for {
+		var msg string
+		if websocket.Message.Receive(wc, &msg) != nil {
+			break
+		}
+		var em worker.ExecMessage
+		if json.Unmarshal([]byte(msg), &em) == nil && (em.Type == worker.ExecExit || em.Type == worker.ExecError) {
+			rec.ExitCode = em.ExitCode
+			rec.Error = em.Error
+		}
+		if websocket.Message.Send(client, msg) != nil {
+			break
+		}
+	}
+	client.Close()
+
+	rec.Event = "end"
+	rec.Duration = time.Since(start)
+	m.AuditExec(rec)
+}
+
+// AuditExec records rec in ExecAudit.
+func (m *Manager) AuditExec(rec ExecRecord) {
+	rec.Time = time.Now()
+	if m.ExecAudit == nil {
+		log.Printf("Manager: Audit: exec %s in task %s on worker %q: cmd %q, tty %v, stdin %v, from %s, exit code %d, error %q, duration %v",
+			rec.Event, rec.Task, rec.Worker, rec.Cmd, rec.Tty, rec.Stdin, rec.RemoteAddr, rec.ExitCode, rec.Error, rec.Duration)
+		return
+	}
+
+	m.auditMu.Lock()
+	defer m.auditMu.Unlock()
+	err := json.NewEncoder(m.ExecAudit).Encode(rec)
+	if err != nil {
+		log.Printf("Manager: Error writing exec audit record for task %s: %v", rec.Task, err)
+	}
+}
@@ -3,13 +3,18 @@ package manager
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"time"
 
+	"github.com/araminian/cube/cron"
+	"github.com/araminian/cube/service"
 	"github.com/araminian/cube/task"
+	"github.com/araminian/cube/worker"
+	"github.com/araminian/cube/workflow"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"golang.org/x/net/websocket"
 )
 
 type ErrResponse struct {
@@ -24,17 +29,20 @@ func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 	te := task.TaskEvent{}
 	err := d.Decode(&te)
 	if err != nil {
-		msg := fmt.Sprintf("failed to decode task event: %v", err)
-		log.Println(msg)
-		e := ErrResponse{
-			HTTPStatusCode: http.StatusBadRequest,
-			Message:        msg,
-		}
-		json.NewEncoder(w).Encode(e)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode task event: %v", err))
 		return
 	}
 
-	a.Manager.AddTask(te)
+	if err := te.Task.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid task: %v", err))
+		return
+	}
+	if len(te.Task.DependsOn) > 0 {
+		writeError(w, http.StatusBadRequest, "tasks with dependencies must be submitted as part of a workflow")
+		return
+	}
+
+	a.Manager.SubmitTask(te)
 	log.Printf("Manager: task added: %+v", te)
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(te.Task)
@@ -47,46 +55,509 @@ func (a *Api) GetTasksHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(tasks)
 }
 
+func (a *Api) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid task id: %v", err))
+		return
+	}
+	t, ok := a.Manager.GetTask(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("task not found: %s", id))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(t)
+}
+
 func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid task id: %v", err))
+		return
+	}
+
+	taskToStop, ok := a.Manager.GetTask(tID)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("task not found: %s", tID))
+		return
+	}
+
+	a.Manager.StopTask(taskToStop)
 
-	taskID := chi.URLParam(r, "taskID")
-	if taskID == "" {
-		msg := "taskID is required"
-		log.Println(msg)
-		e := ErrResponse{
-			HTTPStatusCode: http.StatusBadRequest,
-			Message:        msg,
+	log.Printf("Manager: Added task event to stop task %s", tID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetTaskLogsHandler streams the logs of a task from the worker it runs
+// on. The query parameters are passed on to the worker unchanged.
+func (a *Api) GetTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid task id: %v", err))
+		return
+	}
+
+	resp, err := a.Manager.TaskLogs(r.Context(), id, r.URL.RawQuery)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		switch err {
+		case errTaskNotFound:
+			writeError(w, http.StatusNotFound, fmt.Sprintf("task not found: %s", id))
+		case errNotPlaced:
+			writeError(w, http.StatusConflict, fmt.Sprintf("task %s has not been placed on a worker yet", id))
+		default:
+			writeError(w, http.StatusBadGateway, fmt.Sprintf("failed to get logs of task %s: %v", id, err))
 		}
-		json.NewEncoder(w).Encode(e)
 		return
 	}
+	defer resp.Body.Close()
 
-	tID, _ := uuid.Parse(taskID)
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			rc.Flush()
+		}
+		if err != nil {
+			if err != io.EOF && r.Context().Err() == nil {
+				log.Printf("Manager: Error streaming logs of task %s: %v", id, err)
+			}
+			return
+		}
+	}
+}
 
-	taskToStop, ok := a.Manager.TaskDb[tID]
-	if !ok {
-		msg := fmt.Sprintf("task not found: %s", taskID)
-		log.Println(msg)
-		e := ErrResponse{
-			HTTPStatusCode: http.StatusNotFound,
-			Message:        msg,
+// ExecTaskHandler runs a command in a task on the worker it runs on. The
+// connection is upgraded to a WebSocket, which the manager connects to the
+// one of the worker.
+func (a *Api) ExecTaskHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid task id: %v", err))
+		return
+	}
+	opts, err := worker.ParseExecOptions(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rec := ExecRecord{
+		Task:       id,
+		Cmd:        opts.Cmd,
+		Tty:        opts.Tty,
+		Stdin:      opts.Stdin,
+		RemoteAddr: r.RemoteAddr,
+	}
+	err = worker.CheckExecOrigin(r)
+	if err != nil {
+		rec.Event = "denied"
+		rec.Error = err.Error()
+		a.Manager.AuditExec(rec)
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	wc, n, err := a.Manager.DialExec(id, r.URL.RawQuery)
+	if err != nil {
+		rec.Event = "denied"
+		rec.Error = err.Error()
+		a.Manager.AuditExec(rec)
+		switch err {
+		case errExecDisabled:
+			writeError(w, http.StatusForbidden, "exec is disabled")
+		case errTaskNotFound:
+			writeError(w, http.StatusNotFound, fmt.Sprintf("task not found: %s", id))
+		case errNotPlaced, errTaskNotRunning:
+			writeError(w, http.StatusConflict, fmt.Sprintf("task %s is not running", id))
+		default:
+			writeError(w, http.StatusBadGateway, fmt.Sprintf("failed to exec in task %s: %v", id, err))
+		}
+		return
+	}
+	defer wc.Close()
+
+	rec.Worker = n.Name
+	rec.Event = "start"
+	a.Manager.AuditExec(rec)
+	websocket.Server{
+		Handler: func(client *websocket.Conn) {
+			a.Manager.ProxyExec(client, wc, rec)
+		},
+	}.ServeHTTP(w, r)
+}
+
+// GetEventsHandler lists the task events sent to workers, only those of
+// one task when the task query parameter is given.
+func (a *Api) GetEventsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var taskID uuid.UUID
+	if v := r.URL.Query().Get("task"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid task id: %v", err))
+			return
 		}
-		json.NewEncoder(w).Encode(e)
+		taskID = id
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Manager.GetEvents(taskID))
+}
+
+func (a *Api) GetNodesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Manager.GetNodes())
+}
+
+func (a *Api) GetNodeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name := chi.URLParam(r, "nodeName")
+	n, ok := a.Manager.GetNode(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("node not found: %s", name))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(n)
+}
+
+func (a *Api) RegisterNodeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	hb := worker.Heartbeat{}
+	err := json.NewDecoder(r.Body).Decode(&hb)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode registration: %v", err))
+		return
+	}
+	if hb.Name == "" || hb.Api == "" {
+		writeError(w, http.StatusBadRequest, "node name and api are required")
+		return
+	}
+
+	a.Manager.RegisterNode(hb)
+	n, _ := a.Manager.GetNode(hb.Name)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(n)
+}
+
+func (a *Api) HeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name := chi.URLParam(r, "nodeName")
+	hb := worker.Heartbeat{}
+	err := json.NewDecoder(r.Body).Decode(&hb)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode heartbeat: %v", err))
+		return
+	}
+
+	if !a.Manager.Heartbeat(name, hb.Stats) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("node not found: %s", name))
+		return
+	}
+	n, _ := a.Manager.GetNode(name)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(n)
+}
+
+func (a *Api) DeregisterNodeHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "nodeName")
+	if !a.Manager.RemoveNode(name) {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, http.StatusNotFound, fmt.Sprintf("node not found: %s", name))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Api) CreateCronJobHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	j := cron.Job{}
+	err := d.Decode(&j)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode cron job: %v", err))
+		return
+	}
+
+	j, err = a.Manager.CreateCronJob(j)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid cron job: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(j)
+}
+
+func (a *Api) GetCronJobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Manager.GetCronJobs())
+}
+
+func (a *Api) GetCronJobHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := uuid.Parse(chi.URLParam(r, "cronJobID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid cron job id: %v", err))
+		return
+	}
+	j, ok := a.Manager.GetCronJob(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("cron job not found: %s", id))
 		return
 	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(j)
+}
 
-	te := task.TaskEvent{
-		ID:        uuid.New(),
-		State:     task.Completed,
-		Timestamp: time.Now(),
+func (a *Api) DeleteCronJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "cronJobID"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid cron job id: %v", err))
+		return
 	}
+	if !a.Manager.DeleteCronJob(id) {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, http.StatusNotFound, fmt.Sprintf("cron job not found: %s", id))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	taskCopy := *taskToStop
-	taskCopy.State = task.Completed
-	te.Task = taskCopy
+func (a *Api) CreateWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
 
-	a.Manager.AddTask(te)
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	wf := workflow.Workflow{}
+	err := d.Decode(&wf)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode workflow: %v", err))
+		return
+	}
 
-	log.Printf("Manager: Added task event to stop task %s: %+v", taskID, te)
+	wf, err = a.Manager.CreateWorkflow(wf)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid workflow: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(wf)
+}
+
+func (a *Api) GetWorkflowsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Manager.GetWorkflows())
+}
+
+func (a *Api) GetWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := uuid.Parse(chi.URLParam(r, "workflowID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid workflow id: %v", err))
+		return
+	}
+	wf, ok := a.Manager.GetWorkflow(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("workflow not found: %s", id))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(wf)
+}
+
+func (a *Api) CancelWorkflowHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "workflowID"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid workflow id: %v", err))
+		return
+	}
+	if !a.Manager.CancelWorkflow(id) {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, http.StatusNotFound, fmt.Sprintf("workflow not found: %s", id))
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// ServicePatch holds the fields of a service that PATCH /services/{id} can
+// change. Fields left nil are not changed. Changing Task starts a rolling
+// update.
+type ServicePatch struct {
+	Replicas     *int
+	Task         *task.Task
+	UpdateConfig *service.UpdateConfig
+}
+
+// ServiceRollback is the body of POST /services/{id}/rollback. Revision 0
+// rolls back to the revision the last update started from.
+type ServiceRollback struct {
+	Revision int
+}
+
+func (a *Api) CreateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	s := service.Service{}
+	err := d.Decode(&s)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode service: %v", err))
+		return
+	}
+
+	s, err = a.Manager.CreateService(s)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid service: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s)
+}
+
+func (a *Api) GetServicesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(a.Manager.GetServices())
+}
+
+func (a *Api) GetServiceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := uuid.Parse(chi.URLParam(r, "serviceID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid service id: %v", err))
+		return
+	}
+	s, ok := a.Manager.GetService(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("service not found: %s", id))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(s)
+}
+
+func (a *Api) UpdateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := uuid.Parse(chi.URLParam(r, "serviceID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid service id: %v", err))
+		return
+	}
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	patch := ServicePatch{}
+	err = d.Decode(&patch)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode service update: %v", err))
+		return
+	}
+
+	s, ok := a.Manager.GetService(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("service not found: %s", id))
+		return
+	}
+	if patch.Replicas != nil || patch.Task != nil || patch.UpdateConfig != nil {
+		s, err = a.Manager.UpdateService(id, patch.Replicas, patch.Task, patch.UpdateConfig)
+		if err == errServiceNotFound {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("service not found: %s", id))
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to update service: %v", err))
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(s)
+}
+
+func (a *Api) RollbackServiceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := uuid.Parse(chi.URLParam(r, "serviceID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid service id: %v", err))
+		return
+	}
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	rollback := ServiceRollback{}
+	err = d.Decode(&rollback)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to decode service rollback: %v", err))
+		return
+	}
+
+	s, err := a.Manager.RollbackService(id, rollback.Revision)
+	if err == errServiceNotFound {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("service not found: %s", id))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to roll back service: %v", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(s)
+}
+
+func (a *Api) DeleteServiceHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "serviceID"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid service id: %v", err))
+		return
+	}
+	if !a.Manager.DeleteService(id) {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, http.StatusNotFound, fmt.Sprintf("service not found: %s", id))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	log.Println(msg)
+	w.WriteHeader(status)
+	e := ErrResponse{
+		HTTPStatusCode: status,
+		Message:        msg,
+	}
+	json.NewEncoder(w).Encode(e)
+}
@@ -0,0 +1,56 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/araminian/cube/node"
+	"github.com/google/uuid"
+)
+
+var errNotPlaced = errors.New("task has not been placed on a worker")
+
+// TaskLogs requests the logs of the task with the given id from the worker
+// it was placed on, with query as the query string of the request. The
+// request lasts as long as ctx, and the caller must close the body of the
+// response.
+func (m *Manager) TaskLogs(ctx context.Context, id uuid.UUID, query string) (*http.Response, error) {
+	n, err := m.taskNode(id)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/tasks/%s/logs?%s", n.Api, id, query)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// taskNode returns the node the task with the given id was placed on.
+func (m *Manager) taskNode(id uuid.UUID) (node.Node, error) {
+	t, ok := m.GetTask(id)
+	if !ok {
+		return node.Node{}, errTaskNotFound
+	}
+
+	m.mu.Lock()
+	worker, ok := m.TaskWorkerMap[id]
+	m.mu.Unlock()
+	if !ok {
+		// Finished tasks may have left the map, their worker still has
+		// their container as long as it kept it.
+		worker = t.Worker
+	}
+	if worker == "" {
+		return node.Node{}, errNotPlaced
+	}
+	n, ok := m.GetNode(worker)
+	if !ok {
+		return node.Node{}, errUnknownWorker
+	}
+	return n, nil
+}
@@ -3,66 +3,261 @@ package manager
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"slices"
+	"sync"
 	"time"
 
+	"github.com/araminian/cube/cron"
+	"github.com/araminian/cube/dispatch"
+	"github.com/araminian/cube/node"
+	"github.com/araminian/cube/scheduler"
+	"github.com/araminian/cube/service"
+	"github.com/araminian/cube/store"
 	"github.com/araminian/cube/task"
 	"github.com/araminian/cube/worker"
-	"github.com/golang-collections/collections/queue"
+	"github.com/araminian/cube/workflow"
 	"github.com/google/uuid"
 )
 
+// DefaultParallelism is how many tasks a manager places at once unless
+// configured otherwise.
+const DefaultParallelism = 4
+
 type Manager struct {
-	Pending       queue.Queue
-	TaskDb        map[uuid.UUID]*task.Task
-	EventDb       map[uuid.UUID]*task.TaskEvent
+	Pending *dispatch.Dispatcher[task.TaskEvent]
+	TaskDb  store.Store[task.Task]
+	EventDb store.Store[task.TaskEvent]
+	// CronDb holds the cron jobs. It keeps them in memory unless replaced
+	// before RunCronJobs is started.
+	CronDb store.Store[cron.Job]
+	// WorkflowDb holds the workflows. It keeps them in memory unless
+	// replaced before RunWorkflows is started.
+	WorkflowDb store.Store[workflow.Workflow]
+	// ServiceDb holds the services. It keeps them in memory unless
+	// replaced before ReconcileServices is started.
+	ServiceDb     store.Store[service.Service]
 	Workers       []string
+	WorkerNodes   []*node.Node
 	WorkerTaskMap map[string][]uuid.UUID
 	TaskWorkerMap map[uuid.UUID]string
-	LastWorker    int
+	Scheduler     scheduler.Scheduler
+	// HeartbeatTimeout is how long a node may go without a heartbeat
+	// before it is considered unreachable.
+	HeartbeatTimeout time.Duration
+	// LostTaskGracePeriod is how long a node may be unreachable before its
+	// tasks are considered lost and rescheduled elsewhere.
+	LostTaskGracePeriod time.Duration
+	// MaxRestarts is how often a failed task is restarted when it does not
+	// set its own limit. Restarts are delayed by RestartBackoff, doubling
+	// with every restart up to MaxRestartBackoff.
+	MaxRestarts       int
+	RestartBackoff    time.Duration
+	MaxRestartBackoff time.Duration
+	// Parallelism is how many tasks ProcessTasks places at once. Events
+	// for the same task are always handled one after another.
+	Parallelism int
+	// RetryDelay is how long a task that could not be placed waits before
+	// it is tried again.
+	RetryDelay time.Duration
+	// The intervals at which the loops of the manager run: UpdateTasks,
+	// UpdateNodeStats, CheckNodes, RestartTasks, ReconcileServices,
+	// RunCronJobs and RunWorkflows.
+	UpdateInterval    time.Duration
+	NodeStatsInterval time.Duration
+	NodeCheckInterval time.Duration
+	RestartInterval   time.Duration
+	ServiceInterval   time.Duration
+	CronInterval      time.Duration
+	WorkflowInterval  time.Duration
+	// DisableExec rejects requests to run commands in tasks. ExecAudit
+	// receives a JSON ExecRecord per line for every exec session, when it
+	// is nil the records are logged.
+	DisableExec bool
+	ExecAudit   io.Writer
+	// mu guards the nodes, the task assignments, orphans and updates of
+	// tasks in TaskDb, which the loops and the API handlers all touch. It
+	// is never held while talking to a worker.
+	mu sync.Mutex
+	// cronMu guards updates of the jobs in CronDb.
+	cronMu sync.Mutex
+	// workflowMu guards updates of the workflows in WorkflowDb.
+	workflowMu sync.Mutex
+	// serviceMu guards updates of the services in ServiceDb.
+	serviceMu sync.Mutex
+	// auditMu serializes writes to ExecAudit.
+	auditMu sync.Mutex
+	// orphans holds the tasks that were rescheduled away from a worker and
+	// must be torn down there once it is reachable again.
+	orphans map[string][]uuid.UUID
+	// stopping holds the tasks StopTask was called for that have not
+	// finished yet.
+	stopping map[uuid.UUID]bool
 }
 
-func NewManager(workers []string) *Manager {
-	taskDB := make(map[uuid.UUID]*task.Task)
-	eventDB := make(map[uuid.UUID]*task.TaskEvent)
+// NewManager creates a manager for the given workers that keeps its tasks
+// and events in taskDb and eventDb. When the stores hold state from a
+// previous run, task assignments are restored from it and tasks that were
+// not placed yet are queued again.
+func NewManager(workers []string, schedulerType string, taskDb store.Store[task.Task], eventDb store.Store[task.TaskEvent]) (*Manager, error) {
+	s, err := scheduler.New(schedulerType)
+	if err != nil {
+		return nil, err
+	}
+
 	workerTaskMap := make(map[string][]uuid.UUID)
 	taskWorkerMap := make(map[uuid.UUID]string)
 
+	var nodes []*node.Node
 	for _, w := range workers {
 		workerTaskMap[w] = []uuid.UUID{}
+		nodes = append(nodes, node.NewNode(w, fmt.Sprintf("http://%s", w), "worker"))
 	}
 
-	return &Manager{
-		Pending:       *queue.New(),
+	m := &Manager{
 		Workers:       workers,
+		WorkerNodes:   nodes,
 		WorkerTaskMap: workerTaskMap,
 		TaskWorkerMap: taskWorkerMap,
-		TaskDb:        taskDB,
-		EventDb:       eventDB,
+		TaskDb:        taskDb,
+		EventDb:       eventDb,
+		CronDb:        store.NewMemoryStore[cron.Job](),
+		WorkflowDb:    store.NewMemoryStore[workflow.Workflow](),
+		ServiceDb:     store.NewMemoryStore[service.Service](),
+		Scheduler:     s,
+
+		HeartbeatTimeout:    30 * time.Second,
+		LostTaskGracePeriod: 2 * time.Minute,
+		MaxRestarts:         3,
+		RestartBackoff:      5 * time.Second,
+		MaxRestartBackoff:   5 * time.Minute,
+		Parallelism:         DefaultParallelism,
+		RetryDelay:          10 * time.Second,
+		UpdateInterval:      15 * time.Second,
+		NodeStatsInterval:   15 * time.Second,
+		NodeCheckInterval:   10 * time.Second,
+		RestartInterval:     10 * time.Second,
+		ServiceInterval:     10 * time.Second,
+		CronInterval:        10 * time.Second,
+		WorkflowInterval:    5 * time.Second,
+		orphans:             make(map[string][]uuid.UUID),
+		stopping:            make(map[uuid.UUID]bool),
+	}
+	m.Pending = dispatch.New(func(te task.TaskEvent) string { return te.Task.ID.String() }, m.SendWork)
+
+	err = m.restore()
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// restore rebuilds the in-memory state of the manager from its task store.
+func (m *Manager) restore() error {
+	tasks, err := m.TaskDb.List()
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tasks {
+		if t.Worker != "" && t.State != task.Pending && t.State != task.Lost {
+			m.TaskWorkerMap[t.ID] = t.Worker
+			m.WorkerTaskMap[t.Worker] = append(m.WorkerTaskMap[t.Worker], t.ID)
+			continue
+		}
+		if t.State == task.Pending && t.Workflow != uuid.Nil {
+			// Tasks of workflows may be waiting for their dependencies,
+			// RunWorkflows requeues those that were released.
+			continue
+		}
+		if t.State == task.Pending || t.State == task.Lost {
+			log.Printf("Manager: Requeueing task %s restored in state %v", t.ID, t.State)
+			retry := t
+			retry.State = task.Scheduled
+			m.AddTask(task.TaskEvent{
+				ID:        uuid.New(),
+				State:     task.Scheduled,
+				Timestamp: time.Now(),
+				Task:      retry,
+			})
+		}
+	}
+
+	// Workers that have tasks but are not configured statically get the
+	// usual grace period to register again before their tasks are lost.
+	for worker := range m.WorkerTaskMap {
+		if m.getNode(worker) == nil {
+			n := node.NewNode(worker, "", "worker")
+			n.State = node.Unreachable
+			m.WorkerNodes = append(m.WorkerNodes, n)
+			m.Workers = append(m.Workers, worker)
+		}
 	}
+
+	for _, n := range m.WorkerNodes {
+		m.reserveAssigned(n)
+	}
+	return nil
 }
 
-func (m *Manager) SelectWorker() string {
-	var newWorker int
-	if m.LastWorker+1 < len(m.Workers) {
-		newWorker = m.LastWorker + 1
-		m.LastWorker = newWorker
-	} else {
-		newWorker = 0
-		m.LastWorker = 0
+// reserveAssigned reserves the resources of the unfinished tasks already
+// assigned to n, e.g. when n registers after a manager restart.
+func (m *Manager) reserveAssigned(n *node.Node) {
+	for _, id := range m.WorkerTaskMap[n.Name] {
+		t, err := m.TaskDb.Get(id.String())
+		if err == nil && !isTerminal(t.State) {
+			m.reserveResources(t, n)
+		}
+	}
+}
+
+var (
+	errUnknownWorker = errors.New("unknown worker")
+	errTaskNotFound  = errors.New("task not found on worker")
+)
+
+func (m *Manager) SelectWorker(t task.Task) (*node.Node, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.selectWorker(t)
+}
+
+// selectWorker picks the node to run t on. m.mu must be held.
+func (m *Manager) selectWorker(t task.Task) (*node.Node, error) {
+	ready := m.readyNodes()
+	if len(ready) == 0 {
+		return nil, errors.New("no ready nodes")
+	}
+
+	candidates := m.Scheduler.SelectCandidateNodes(t, ready)
+	if len(candidates) == 0 {
+		if ports := t.ReservedHostPorts(); len(ports) > 0 {
+			return nil, fmt.Errorf("no node has capacity and free host ports %v for task %s (memory %d, disk %d)", ports, t.ID, t.Memory, t.Disk)
+		}
+		return nil, fmt.Errorf("no node has capacity for task %s (memory %d, disk %d)", t.ID, t.Memory, t.Disk)
+	}
+
+	scores := m.Scheduler.Score(t, candidates)
+	selected := m.Scheduler.Pick(scores, candidates)
+	if selected == nil {
+		return nil, fmt.Errorf("scheduler picked no node for task %s", t.ID)
 	}
-	return m.Workers[newWorker]
+	return selected, nil
 }
 
+// ProcessTasks sends queued tasks to workers as they arrive, up to
+// Parallelism at once.
 func (m *Manager) ProcessTasks() {
-	for {
-		log.Println("Manager: Processing tasks")
-		m.SendWork()
-		log.Println("Manager: Sleeping for 10 seconds")
-		time.Sleep(10 * time.Second)
+	parallelism := m.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
 	}
+	log.Printf("Manager: Processing tasks with parallelism %d", parallelism)
+	m.Pending.Run(parallelism)
 }
 
 func (m *Manager) UpdateTasks() {
@@ -70,123 +265,416 @@ func (m *Manager) UpdateTasks() {
 	for {
 		log.Println("Manager: Checking for tasks updates")
 		m.updateTasks()
-		log.Println("Manager: Sleeping for 15 seconds")
-		time.Sleep(15 * time.Second)
+		log.Printf("Manager: Sleeping for %v", m.UpdateInterval)
+		time.Sleep(m.UpdateInterval)
 	}
 
 }
 
 func (m *Manager) updateTasks() {
-	for _, worker := range m.Workers {
+	for _, n := range m.GetNodes() {
+		if n.State != node.Ready {
+			continue
+		}
+		worker := n.Name
 		log.Printf("Manager: Checking worker %s for tasks updates", worker)
-		url := fmt.Sprintf("http://%s/tasks", worker)
-		resp, err := http.Get(url)
+		tasks, err := m.fetchTasks(n)
 		if err != nil {
 			log.Printf("Manager: Error getting tasks from worker %s: %v", worker, err)
 			continue
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("Manager: Non-200 response from worker %s: %d", worker, resp.StatusCode)
-			continue
+		m.mu.Lock()
+		for _, t := range tasks {
+			m.updateTask(t, worker)
 		}
+		m.mu.Unlock()
+	}
+}
 
-		d := json.NewDecoder(resp.Body)
-		var tasks []*task.Task
-		err = d.Decode(&tasks)
-		if err != nil {
-			log.Printf("Manager: Error decoding tasks from worker %s: %v", worker, err)
-			continue
-		}
+// fetchTasks gets the tasks the worker running on n knows about.
+func (m *Manager) fetchTasks(n *node.Node) ([]task.Task, error) {
+	url := fmt.Sprintf("%s/tasks", n.Api)
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-		for _, t := range tasks {
-			log.Printf("Manager: Attempting to update task %v", t.ID)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-200 response from %s: %d", url, resp.StatusCode)
+	}
 
-			_, ok := m.TaskDb[t.ID]
-			if !ok {
-				log.Printf("Manager: Task %v not found in task db", t.ID)
-				continue
-			}
+	var tasks []task.Task
+	err = json.NewDecoder(resp.Body).Decode(&tasks)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding tasks: %v", err)
+	}
+	return tasks, nil
+}
 
-			if m.TaskDb[t.ID].State != t.State {
-				m.TaskDb[t.ID].State = t.State
-			}
+// updateTask records the state of t as reported by worker. m.mu must be
+// held.
+func (m *Manager) updateTask(t task.Task, worker string) {
+	log.Printf("Manager: Attempting to update task %v", t.ID)
 
-			m.TaskDb[t.ID].StartTime = t.StartTime
-			m.TaskDb[t.ID].FinishTime = t.FinishTime
-			m.TaskDb[t.ID].ContainerID = t.ContainerID
+	persisted, err := m.TaskDb.Get(t.ID.String())
+	if err != nil {
+		// Finished tasks the manager has no record of were deleted, e.g.
+		// as part of the history of a cron job.
+		if isTerminal(t.State) {
+			return
+		}
+		if n := m.getNode(worker); n != nil {
+			m.adoptTask(t, n)
 		}
+		return
 	}
-}
 
-func (m *Manager) SendWork() {
-	if m.Pending.Len() > 0 {
+	if m.TaskWorkerMap[t.ID] != worker {
+		log.Printf("Manager: Task %v is no longer assigned to worker %s, ignoring its update", t.ID, worker)
+		return
+	}
 
-		w := m.SelectWorker()
+	if persisted.State != t.State {
+		if isTerminal(t.State) && !isTerminal(persisted.State) {
+			m.releaseResources(persisted, worker)
+			delete(m.stopping, t.ID)
+		}
+		persisted.State = t.State
+	}
 
-		e := m.Pending.Dequeue()
-		te := e.(task.TaskEvent)
-		t := te.Task
-		log.Printf("Pulled %v off pending queue", t)
+	persisted.Health = t.Health
+	persisted.Reason = t.Reason
+	persisted.StartTime = t.StartTime
+	persisted.FinishTime = t.FinishTime
+	persisted.ContainerID = t.ContainerID
+	persisted.HostPorts = t.HostPorts
+	persisted.ExitCode = t.ExitCode
+	persisted.OOMKilled = t.OOMKilled
+	persisted.Error = t.Error
+	m.saveTask(persisted)
+}
 
-		m.EventDb[te.ID] = &te
-		m.WorkerTaskMap[w] = append(m.WorkerTaskMap[w], t.ID)
-		m.TaskWorkerMap[t.ID] = w
+// SendWork places the task of te on a worker, or stops it on the worker it
+// runs on when te is a stop event. Tasks that cannot be placed are tried
+// again after RetryDelay.
+func (m *Manager) SendWork(te task.TaskEvent) {
+	t := te.Task
+	log.Printf("Pulled %v off pending queue", t)
 
-		t.State = task.Scheduled
+	m.mu.Lock()
+	persisted, err := m.TaskDb.Get(t.ID.String())
+	known := err == nil
 
-		data, err := json.Marshal(te)
-		if err != nil {
-			log.Printf("Error marshalling task: %v", err)
+	if te.State == task.Completed && known && persisted.State == task.Failed {
+		// The container of a failed task is gone already, stopping it
+		// only keeps it from being restarted.
+		persisted.State = task.Completed
+		persisted.Reason = "stopped after it failed"
+		if worker, ok := m.TaskWorkerMap[t.ID]; ok {
+			m.unassignTask(persisted, worker)
 		}
+		delete(m.stopping, t.ID)
+		m.saveTask(persisted)
+		m.mu.Unlock()
+		return
+	}
 
-		url := fmt.Sprintf("http://%s/tasks", w)
-
-		resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
-		if err != nil {
-			log.Printf("Error sending work %v to worker %s: %v", te, w, err)
-			m.Pending.Enqueue(te)
+	taskWorker, ok := m.TaskWorkerMap[t.ID]
+	if ok {
+		m.mu.Unlock()
+		if te.State == task.Completed && known && !isTerminal(persisted.State) {
+			err := m.stopTask(taskWorker, t.ID)
+			if err != nil {
+				// The worker may not have taken the task yet or be
+				// unreachable for a moment.
+				log.Printf("Manager: Trying to stop task %s again in %v", t.ID, m.RetryDelay)
+				m.Pending.AddAfter(te, m.RetryDelay)
+				return
+			}
+			te.Task.Worker = taskWorker
+			err = m.EventDb.Put(te.ID.String(), te)
+			if err != nil {
+				log.Printf("Error saving event %s: %v", te.ID, err)
+			}
 			return
 		}
-		defer resp.Body.Close()
+		log.Printf("Manager: Task %s already runs on worker %s in state %v, ignoring event in state %v", t.ID, taskWorker, persisted.State, te.State)
+		return
+	}
 
-		d := json.NewDecoder(resp.Body)
+	if known && isTerminal(persisted.State) {
+		delete(m.stopping, t.ID)
+		m.mu.Unlock()
+		log.Printf("Manager: Task %s was stopped before being scheduled, dropping it", t.ID)
+		return
+	}
+	if te.State == task.Completed {
+		if known {
+			persisted.State = task.Completed
+			persisted.Reason = "stopped before being scheduled"
+			m.saveTask(persisted)
+		}
+		delete(m.stopping, t.ID)
+		m.mu.Unlock()
+		return
+	}
 
-		if resp.StatusCode != http.StatusCreated {
-			e := worker.ErrorResponse{}
-			err := d.Decode(&e)
-			if err != nil {
-				log.Printf("Error decoding error response from worker %s: %v", w, err)
-				return
-			} else {
-				log.Printf("Response %d Error from worker %s: %s", e.HTTPStatus, w, e.Message)
-				return
-				// Should i readd the task to pending queue?
-			}
+	n, err := m.selectWorker(t)
+	if err != nil {
+		log.Printf("Error selecting worker for task %s: %v", t.ID, err)
+		pending := t
+		pending.State = task.Pending
+		pending.Reason = err.Error()
+		m.saveTask(pending)
+		m.mu.Unlock()
+		m.Pending.AddAfter(te, m.RetryDelay)
+		return
+	}
+	w := n.Name
+	api := n.Api
+
+	te.Task.State = task.Scheduled
+	te.Task.Worker = w
+	t = te.Task
+
+	m.WorkerTaskMap[w] = append(m.WorkerTaskMap[w], t.ID)
+	m.TaskWorkerMap[t.ID] = w
+	m.reserveResources(t, n)
+	m.saveTask(t)
+	m.mu.Unlock()
+
+	err = m.EventDb.Put(te.ID.String(), te)
+	if err != nil {
+		log.Printf("Error saving event %s: %v", te.ID, err)
+	}
+
+	data, err := json.Marshal(te)
+	if err != nil {
+		log.Printf("Error marshalling task: %v", err)
+	}
+
+	url := fmt.Sprintf("%s/tasks", api)
+
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		log.Printf("Error sending work %v to worker %s: %v", te, w, err)
+		m.mu.Lock()
+		m.releaseResources(t, w)
+		m.unassignTask(t, w)
+		m.mu.Unlock()
+		m.Pending.AddAfter(te, m.RetryDelay)
+		return
+	}
+	defer resp.Body.Close()
+
+	d := json.NewDecoder(resp.Body)
+
+	if resp.StatusCode != http.StatusCreated {
+		e := worker.ErrorResponse{}
+		msg := fmt.Sprintf("unexpected status %d", resp.StatusCode)
+		if err := d.Decode(&e); err == nil && e.Message != "" {
+			msg = e.Message
 		}
-		t = task.Task{}
-		err = d.Decode(&t)
-		if err != nil {
-			log.Printf("Error decoding task response from worker %s: %v", w, err)
+		log.Printf("Manager: Worker %s refused task %s with status %d: %s", w, t.ID, resp.StatusCode, msg)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.releaseResources(t, w)
+		m.unassignTask(t, w)
+		if resp.StatusCode >= http.StatusInternalServerError {
+			m.Pending.AddAfter(te, m.RetryDelay)
 			return
 		}
-		log.Printf("Received task %v from worker %s", t, w)
-		m.TaskDb[t.ID] = &t
-	} else {
-		log.Println("Manager: No tasks to send")
+		// The worker won't take the task as it is, so there is no point in
+		// trying again.
+		t.State = task.Failed
+		t.Reason = fmt.Sprintf("worker %s refused the task: %s", w, msg)
+		t.FinishTime = time.Now()
+		m.saveTask(t)
+		return
+	}
+	t = task.Task{}
+	err = d.Decode(&t)
+	if err != nil {
+		log.Printf("Error decoding task response from worker %s: %v", w, err)
+		return
+	}
+	log.Printf("Received task %v from worker %s", t, w)
+}
+
+// adoptTask takes over a task reported by the worker on n that the manager
+// has no record of, e.g. one the worker found running after it restarted.
+// m.mu must be held.
+func (m *Manager) adoptTask(t task.Task, n *node.Node) {
+	log.Printf("Manager: Adopting task %v reported by worker %s", t.ID, n.Name)
+	t.Worker = n.Name
+	m.WorkerTaskMap[n.Name] = append(m.WorkerTaskMap[n.Name], t.ID)
+	m.TaskWorkerMap[t.ID] = n.Name
+	if !isTerminal(t.State) {
+		m.reserveResources(t, n)
+	}
+	m.saveTask(t)
+}
+
+func (m *Manager) stopTask(worker string, taskID uuid.UUID) error {
+	n, ok := m.GetNode(worker)
+	if !ok {
+		log.Printf("Manager: Unknown worker %s for task %s", worker, taskID)
+		return errUnknownWorker
+	}
+
+	url := fmt.Sprintf("%s/tasks/%s", n.Api, taskID)
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		log.Printf("Manager: Error creating request to stop task %s: %v", taskID, err)
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Manager: Error connecting to worker %s to stop task %s: %v", worker, taskID, err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		log.Printf("Manager: Task %s to stop is not known to worker %s", taskID, worker)
+		return errTaskNotFound
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		log.Printf("Manager: Error stopping task %s on worker %s: status %d", taskID, worker, resp.StatusCode)
+		return fmt.Errorf("unexpected status %d stopping task %s", resp.StatusCode, taskID)
+	}
+	log.Printf("Manager: Task %s has been scheduled to be stopped on worker %s", taskID, worker)
+	return nil
+}
+
+// reserveResources accounts for t on n until the task finishes. m.mu must be
+// held, as for releaseResources and unassignTask.
+func (m *Manager) reserveResources(t task.Task, n *node.Node) {
+	n.TaskCounts++
+	n.MemoryAllocated += t.Memory
+	n.DiskAllocated += t.Disk
+	n.AllocateHostPorts(t.ReservedHostPorts())
+}
+
+// releaseResources gives back what reserveResources took for t on worker.
+func (m *Manager) releaseResources(t task.Task, worker string) {
+	n := m.getNode(worker)
+	if n == nil {
+		return
+	}
+	n.TaskCounts = max(n.TaskCounts-1, 0)
+	n.MemoryAllocated = max(n.MemoryAllocated-t.Memory, 0)
+	n.DiskAllocated = max(n.DiskAllocated-t.Disk, 0)
+	n.ReleaseHostPorts(t.ReservedHostPorts())
+}
+
+// unassignTask removes t from the tasks assigned to worker.
+func (m *Manager) unassignTask(t task.Task, worker string) {
+	delete(m.TaskWorkerMap, t.ID)
+	ids := m.WorkerTaskMap[worker]
+	for i, id := range ids {
+		if id == t.ID {
+			m.WorkerTaskMap[worker] = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+}
+
+func (m *Manager) saveTask(t task.Task) {
+	err := m.TaskDb.Put(t.ID.String(), t)
+	if err != nil {
+		log.Printf("Manager: Error saving task %s: %v", t.ID, err)
 	}
+}
 
+func isTerminal(s task.State) bool {
+	return s == task.Completed || s == task.Failed
 }
 
 func (m *Manager) AddTask(te task.TaskEvent) {
-	m.Pending.Enqueue(te)
+	m.Pending.Add(te)
 }
 
-func (m *Manager) GetTasks() []*task.Task {
-	tasks := []*task.Task{}
-	for _, t := range m.TaskDb {
-		tasks = append(tasks, t)
+// StopTask queues t to be stopped on the worker it runs on.
+// Finished tasks are not marked as stopping, the stop only keeps a failed
+// one from being restarted.
+func (m *Manager) StopTask(t task.Task) {
+	if !isTerminal(t.State) {
+		m.mu.Lock()
+		m.stopping[t.ID] = true
+		m.mu.Unlock()
+	}
+
+	stopped := t
+	stopped.State = task.Completed
+	m.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Completed,
+		Timestamp: time.Now(),
+		Task:      stopped,
+	})
+}
+
+// isStopping reports whether StopTask was called for t and t has not
+// finished yet.
+func (m *Manager) isStopping(t task.Task) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.stopping[t.ID] {
+		return false
+	}
+	if isTerminal(t.State) {
+		delete(m.stopping, t.ID)
+		return false
+	}
+	return true
+}
+
+// SubmitTask records the task of te as pending and queues it for placement.
+func (m *Manager) SubmitTask(te task.TaskEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.TaskDb.Get(te.Task.ID.String()); err == store.ErrNotFound {
+		pending := te.Task
+		pending.State = task.Pending
+		m.saveTask(pending)
+	}
+	m.AddTask(te)
+}
+
+func (m *Manager) GetTask(id uuid.UUID) (task.Task, bool) {
+	t, err := m.TaskDb.Get(id.String())
+	return t, err == nil
+}
+
+func (m *Manager) GetTasks() []task.Task {
+	tasks, err := m.TaskDb.List()
+	if err != nil {
+		log.Printf("Manager: Error listing tasks: %v", err)
+		return []task.Task{}
 	}
 	return tasks
 }
+
+// GetEvents returns the task events sent to workers, oldest first. When
+// taskID is not zero only the events of that task are returned.
+func (m *Manager) GetEvents(taskID uuid.UUID) []task.TaskEvent {
+	events, err := m.EventDb.List()
+	if err != nil {
+		log.Printf("Manager: Error listing events: %v", err)
+		return []task.TaskEvent{}
+	}
+	if taskID != uuid.Nil {
+		events = slices.DeleteFunc(events, func(te task.TaskEvent) bool {
+			return te.Task.ID != taskID
+		})
+	}
+	slices.SortStableFunc(events, func(a, b task.TaskEvent) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	return events
+}
@@ -0,0 +1,408 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/araminian/cube/node"
+	"github.com/araminian/cube/stats"
+	"github.com/araminian/cube/store"
+	"github.com/araminian/cube/task"
+	"github.com/araminian/cube/worker"
+	"github.com/c9s/goprocinfo/linux"
+	"github.com/google/uuid"
+)
+
+// testInterval is how often the loops of test managers and workers run.
+const testInterval = 10 * time.Millisecond
+
+// nodeMemory is the memory of the nodes of test clusters.
+const nodeMemory = 1 << 30
+
+// testCluster is a manager with workers on fake runtimes, all in process.
+type testCluster struct {
+	Manager  *Manager
+	Server   *httptest.Server
+	Runtimes []*task.FakeRuntime
+}
+
+func newTestCluster(t *testing.T, workers int) *testCluster {
+	t.Helper()
+	m, err := NewManager(nil, "roundrobin", store.NewMemoryStore[task.Task](), store.NewMemoryStore[task.TaskEvent]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.RetryDelay = testInterval
+	m.UpdateInterval = testInterval
+	m.RestartInterval = testInterval
+	m.RestartBackoff = testInterval
+	m.WorkflowInterval = testInterval
+	m.ServiceInterval = testInterval
+
+	c := &testCluster{Manager: m}
+	for i := range workers {
+		rt := task.NewFakeRuntime()
+		api := startTestWorker(t, fmt.Sprintf("worker-%d", i), rt)
+		m.RegisterNode(worker.Heartbeat{
+			Name: fmt.Sprintf("worker-%d", i),
+			Api:  api,
+			Stats: stats.Stats{
+				MemStats: &linux.MemInfo{MemTotal: nodeMemory / 1024},
+				CpuCount: 4,
+			},
+		})
+		c.Runtimes = append(c.Runtimes, rt)
+	}
+
+	go m.ProcessTasks()
+	go m.UpdateTasks()
+	go m.RestartTasks()
+	go m.RunWorkflows()
+	go m.ReconcileServices()
+
+	a := &Api{Manager: m}
+	a.initRouter()
+	c.Server = httptest.NewServer(a.Router)
+	t.Cleanup(c.Server.Close)
+	return c
+}
+
+// startTestWorker starts a worker with its API on a free port and returns
+// the URL of the API.
+func startTestWorker(t *testing.T, name string, rt task.Runtime) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	w, err := worker.NewWorker(name, store.NewMemoryStore[task.Task](), rt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.InspectInterval = testInterval
+	go w.RunTask()
+	go w.ObserveTasks()
+	go (&worker.API{Worker: w, Address: "127.0.0.1", Port: port}).Start()
+
+	api := fmt.Sprintf("http://127.0.0.1:%d", port)
+	waitFor(t, "worker API to listen", func() bool {
+		resp, err := http.Get(api + "/tasks")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return true
+	})
+	return api
+}
+
+// waitFor polls cond until it holds and fails the test after five seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(testInterval)
+	}
+}
+
+// submit submits tk through the API. Like stop, it may be called from
+// other goroutines than the test's.
+func (c *testCluster) submit(t *testing.T, tk task.Task) task.Task {
+	t.Helper()
+	if tk.ID == uuid.Nil {
+		tk.ID = uuid.New()
+	}
+	tk.State = task.Pending
+	data, _ := json.Marshal(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Scheduled,
+		Timestamp: time.Now(),
+		Task:      tk,
+	})
+	resp, err := http.Post(c.Server.URL+"/tasks", "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Error(err)
+		return tk
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("submitting task %s: status %d", tk.Name, resp.StatusCode)
+	}
+	return tk
+}
+
+func (c *testCluster) stop(t *testing.T, id uuid.UUID) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodDelete, c.Server.URL+"/tasks/"+id.String(), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("stopping task %s: status %d", id, resp.StatusCode)
+	}
+}
+
+// get decodes the response to GET path into out and reports whether that
+// succeeded.
+func (c *testCluster) get(path string, out any) bool {
+	resp, err := http.Get(c.Server.URL + path)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	return json.NewDecoder(resp.Body).Decode(out) == nil
+}
+
+func (c *testCluster) task(t *testing.T, id uuid.UUID) task.Task {
+	t.Helper()
+	var tk task.Task
+	if !c.get("/tasks/"+id.String(), &tk) {
+		t.Fatalf("getting task %s failed", id)
+	}
+	return tk
+}
+
+func (c *testCluster) nodes(t *testing.T) []node.Node {
+	t.Helper()
+	var nodes []node.Node
+	if !c.get("/nodes", &nodes) {
+		t.Fatal("getting nodes failed")
+	}
+	return nodes
+}
+
+func (c *testCluster) waitForState(t *testing.T, id uuid.UUID, state task.State) task.Task {
+	t.Helper()
+	var tk task.Task
+	waitFor(t, fmt.Sprintf("task %s to be %v", id, state), func() bool {
+		tk = c.task(t, id)
+		return tk.State == state
+	})
+	return tk
+}
+
+func TestCrashingJobFails(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.Runtimes[0].SetBehavior("crash", task.FakeBehavior{CrashAfter: 20 * time.Millisecond, ExitCode: 2})
+
+	tk := c.submit(t, task.Task{Name: "crash", Image: "crash", Mode: task.ModeJob, Memory: 64 << 20})
+	tk = c.waitForState(t, tk.ID, task.Failed)
+	if tk.ExitCode != 2 || tk.Worker != "worker-0" {
+		t.Errorf("failed task exited with %d on %q, want 2 on worker-0", tk.ExitCode, tk.Worker)
+	}
+
+	// A job without retries is not restarted.
+	time.Sleep(5 * testInterval)
+	if tk = c.task(t, tk.ID); tk.State != task.Failed || tk.RestartCount != 0 {
+		t.Errorf("task is %v after %d restarts, want Failed without restarts", tk.State, tk.RestartCount)
+	}
+	n := c.nodes(t)[0]
+	if n.TaskCounts != 0 || n.MemoryAllocated != 0 {
+		t.Errorf("node still accounts for %d tasks using %d bytes", n.TaskCounts, n.MemoryAllocated)
+	}
+}
+
+func TestCrashingTaskIsRestarted(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.Runtimes[0].SetBehavior("crash", task.FakeBehavior{CrashAfter: 20 * time.Millisecond, ExitCode: 1})
+
+	tk := c.submit(t, task.Task{Name: "crash", Image: "crash", MaxRestarts: 2})
+	waitFor(t, "task to use up its restarts", func() bool {
+		tk = c.task(t, tk.ID)
+		return tk.State == task.Failed && tk.RestartCount == 2
+	})
+	if tk.ExitCode != 1 {
+		t.Errorf("task exited with %d, want 1", tk.ExitCode)
+	}
+}
+
+func TestConcurrentSubmitAndStop(t *testing.T) {
+	c := newTestCluster(t, 2)
+	for _, rt := range c.Runtimes {
+		rt.Default = task.FakeBehavior{StartDelay: 5 * time.Millisecond}
+	}
+
+	// Keep reading tasks and nodes while they change.
+	done := make(chan struct{})
+	var polls sync.WaitGroup
+	for _, path := range []string{"/tasks", "/nodes"} {
+		polls.Add(1)
+		go func() {
+			defer polls.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				var out []json.RawMessage
+				c.get(path, &out)
+			}
+		}()
+	}
+
+	const tasks = 20
+	ids := make([]uuid.UUID, tasks)
+	var wg sync.WaitGroup
+	for i := range tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tk := task.Task{
+				Name:   fmt.Sprintf("task-%d", i),
+				Image:  "nginx",
+				Memory: 32 << 20,
+			}
+			if i%2 == 0 {
+				tk.PortBindings = map[string]string{"80/tcp": strconv.Itoa(20000 + i)}
+			}
+			tk = c.submit(t, tk)
+			ids[i] = tk.ID
+			// Stop some tasks right away, whatever state they are in,
+			// and the others once they run.
+			if i%3 != 0 {
+				running := func() bool {
+					var got task.Task
+					return c.get("/tasks/"+tk.ID.String(), &got) && got.State == task.Running
+				}
+				deadline := time.Now().Add(5 * time.Second)
+				for !running() && time.Now().Before(deadline) {
+					time.Sleep(testInterval)
+				}
+			}
+			c.stop(t, tk.ID)
+		}()
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		c.waitForState(t, id, task.Completed)
+	}
+	close(done)
+	polls.Wait()
+
+	waitFor(t, "nodes to release all tasks", func() bool {
+		for _, n := range c.nodes(t) {
+			if n.TaskCounts != 0 || n.MemoryAllocated != 0 || len(n.HostPortsAllocated) != 0 {
+				return false
+			}
+		}
+		return true
+	})
+	for _, rt := range c.Runtimes {
+		waitFor(t, "containers to be removed", func() bool {
+			containers, _ := rt.List(context.Background(), nil)
+			return len(containers) == 0
+		})
+	}
+	c.checkNotStopping(t)
+}
+
+// checkNotStopping fails the test when tasks are still marked as stopping.
+func (c *testCluster) checkNotStopping(t *testing.T) {
+	t.Helper()
+	c.Manager.mu.Lock()
+	defer c.Manager.mu.Unlock()
+	if len(c.Manager.stopping) != 0 {
+		t.Errorf("%d tasks are still marked as stopping", len(c.Manager.stopping))
+	}
+}
+
+func TestStopFinishedTask(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.Runtimes[0].SetBehavior("crash", task.FakeBehavior{CrashAfter: 10 * time.Millisecond, ExitCode: 1})
+
+	tk := c.submit(t, task.Task{Name: "crash", Image: "crash", Mode: task.ModeJob})
+	c.waitForState(t, tk.ID, task.Failed)
+	c.stop(t, tk.ID)
+	tk = c.waitForState(t, tk.ID, task.Completed)
+	if tk.Reason != "stopped after it failed" {
+		t.Errorf("stopped task has reason %q", tk.Reason)
+	}
+
+	// Stopping it again changes nothing.
+	c.stop(t, tk.ID)
+	time.Sleep(5 * testInterval)
+	if got := c.task(t, tk.ID); got.State != task.Completed {
+		t.Errorf("task is %v after stopping it twice", got.State)
+	}
+	c.checkNotStopping(t)
+}
+
+func TestStopRetriedUntilWorkerHasTask(t *testing.T) {
+	c := newTestCluster(t, 1)
+
+	// Place the task on a worker without the worker knowing it, as if the
+	// stop arrived before the start.
+	m := c.Manager
+	tk := task.Task{ID: uuid.New(), Name: "web", Image: "nginx", State: task.Scheduled, Worker: "worker-0"}
+	m.mu.Lock()
+	m.WorkerTaskMap["worker-0"] = append(m.WorkerTaskMap["worker-0"], tk.ID)
+	m.TaskWorkerMap[tk.ID] = "worker-0"
+	m.reserveResources(tk, m.getNode("worker-0"))
+	m.saveTask(tk)
+	m.mu.Unlock()
+	c.stop(t, tk.ID)
+	time.Sleep(5 * testInterval)
+
+	// The start arrives late, the stop still gets to it.
+	n, _ := m.GetNode("worker-0")
+	data, _ := json.Marshal(task.TaskEvent{ID: uuid.New(), State: task.Scheduled, Timestamp: time.Now(), Task: tk})
+	resp, err := http.Post(n.Api+"/tasks", "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	c.waitForState(t, tk.ID, task.Completed)
+	waitFor(t, "container to be removed", func() bool {
+		containers, _ := c.Runtimes[0].List(context.Background(), nil)
+		return len(containers) == 0
+	})
+	c.checkNotStopping(t)
+}
+
+func TestStopEventIsRecorded(t *testing.T) {
+	c := newTestCluster(t, 1)
+
+	tk := c.submit(t, task.Task{Name: "web", Image: "nginx"})
+	c.waitForState(t, tk.ID, task.Running)
+	c.stop(t, tk.ID)
+	c.waitForState(t, tk.ID, task.Completed)
+
+	var events []task.TaskEvent
+	if !c.get("/events?task="+tk.ID.String(), &events) {
+		t.Fatal("getting events failed")
+	}
+	var states []task.State
+	for _, te := range events {
+		states = append(states, te.State)
+		if te.Task.Worker != "worker-0" {
+			t.Errorf("%v event is for worker %q, want worker-0", te.State, te.Task.Worker)
+		}
+	}
+	if len(states) != 2 || states[0] != task.Scheduled || states[1] != task.Completed {
+		t.Errorf("events of the task are %v, want it scheduled and stopped", states)
+	}
+}
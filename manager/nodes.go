@@ -0,0 +1,275 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/araminian/cube/node"
+	"github.com/araminian/cube/stats"
+	"github.com/araminian/cube/task"
+	"github.com/araminian/cube/worker"
+	"github.com/google/uuid"
+)
+
+// RegisterNode adds the worker described by hb to the registry, or updates it
+// when a node with that name is already known. It reports whether the node
+// is new.
+func (m *Manager) RegisterNode(hb worker.Heartbeat) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := m.getNode(hb.Name)
+	if n != nil {
+		n.Api = hb.Api
+		m.markSeen(n, hb.Stats)
+		log.Printf("Manager: Node %s re-registered at %s", n.Name, n.Api)
+		return false
+	}
+
+	n = node.NewNode(hb.Name, hb.Api, "worker")
+	n.UpdateStats(hb.Stats)
+	m.WorkerNodes = append(m.WorkerNodes, n)
+	m.Workers = append(m.Workers, n.Name)
+	if _, ok := m.WorkerTaskMap[n.Name]; !ok {
+		m.WorkerTaskMap[n.Name] = []uuid.UUID{}
+	}
+	m.reserveAssigned(n)
+	log.Printf("Manager: Node %s registered at %s", n.Name, n.Api)
+	return true
+}
+
+// Heartbeat records that the named node is alive. It reports false when the
+// node is not registered.
+func (m *Manager) Heartbeat(name string, s stats.Stats) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := m.getNode(name)
+	if n == nil {
+		return false
+	}
+	m.markSeen(n, s)
+	return true
+}
+
+// RemoveNode drops the named node from the registry. It reports false when
+// the node is not registered.
+func (m *Manager) RemoveNode(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	found := false
+	for i, n := range m.WorkerNodes {
+		if n.Name == name {
+			m.WorkerNodes = append(m.WorkerNodes[:i], m.WorkerNodes[i+1:]...)
+			found = true
+			break
+		}
+	}
+	for i, w := range m.Workers {
+		if w == name {
+			m.Workers = append(m.Workers[:i], m.Workers[i+1:]...)
+			break
+		}
+	}
+	if found {
+		log.Printf("Manager: Node %s left the cluster", name)
+	}
+	return found
+}
+
+// markSeen records stats s reported by n and marks n ready. m.mu must be
+// held.
+func (m *Manager) markSeen(n *node.Node, s stats.Stats) {
+	n.UpdateStats(s)
+	if n.State != node.Ready {
+		log.Printf("Manager: Node %s is %s again", n.Name, node.Ready)
+		n.State = node.Ready
+	}
+}
+
+func (m *Manager) CheckNodes() {
+	for {
+		log.Println("Manager: Checking node heartbeats")
+		m.checkNodes()
+		log.Printf("Manager: Sleeping for %v", m.NodeCheckInterval)
+		time.Sleep(m.NodeCheckInterval)
+	}
+}
+
+// checkNodes marks nodes that have not been heard from within
+// HeartbeatTimeout as unreachable, reschedules the tasks of nodes that stayed
+// unreachable for longer than LostTaskGracePeriod and tears down the tasks
+// that were rescheduled away from nodes that came back.
+func (m *Manager) checkNodes() {
+	m.mu.Lock()
+	var ready []string
+	for _, n := range m.WorkerNodes {
+		if n.State == node.Ready && time.Since(n.LastSeen) > m.HeartbeatTimeout {
+			log.Printf("Manager: Node %s missed heartbeats since %v, marking it %s", n.Name, n.LastSeen, node.Unreachable)
+			n.State = node.Unreachable
+		}
+		if n.State == node.Unreachable && time.Since(n.LastSeen) > m.LostTaskGracePeriod {
+			m.rescheduleTasks(n.Name, fmt.Sprintf("node %s unreachable since %v", n.Name, n.LastSeen.Format(time.RFC3339)))
+		}
+		if n.State == node.Ready {
+			ready = append(ready, n.Name)
+		}
+	}
+
+	// Tasks can also be left behind by nodes that left the cluster.
+	for worker := range m.WorkerTaskMap {
+		if m.getNode(worker) == nil {
+			m.rescheduleTasks(worker, fmt.Sprintf("node %s left the cluster", worker))
+			delete(m.WorkerTaskMap, worker)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, worker := range ready {
+		m.cleanupOrphans(worker)
+	}
+}
+
+// rescheduleTasks marks the unfinished tasks assigned to worker as lost and
+// puts them back on the pending queue so they get placed elsewhere. m.mu
+// must be held.
+func (m *Manager) rescheduleTasks(worker string, reason string) {
+	for _, id := range append([]uuid.UUID{}, m.WorkerTaskMap[worker]...) {
+		t, err := m.TaskDb.Get(id.String())
+		if err != nil || isTerminal(t.State) {
+			continue
+		}
+
+		m.releaseResources(t, worker)
+		m.unassignTask(t, worker)
+		m.orphans[worker] = append(m.orphans[worker], id)
+
+		if m.stopping[id] {
+			log.Printf("Manager: Task %s on worker %s was being stopped, not rescheduling it", id, worker)
+			delete(m.stopping, id)
+			t.State = task.Completed
+			t.Reason = fmt.Sprintf("stopped while %s", reason)
+			t.FinishTime = time.Now()
+			m.saveTask(t)
+			continue
+		}
+
+		log.Printf("Manager: Task %s on worker %s is lost, rescheduling it", id, worker)
+		t.State = task.Lost
+		t.Reason = reason
+		m.saveTask(t)
+
+		retry := t
+		retry.State = task.Scheduled
+		retry.ContainerID = ""
+		retry.Reason = ""
+		m.AddTask(task.TaskEvent{
+			ID:        uuid.New(),
+			State:     task.Scheduled,
+			Timestamp: time.Now(),
+			Task:      retry,
+		})
+	}
+}
+
+// cleanupOrphans stops the tasks that were rescheduled away from worker
+// while it was unreachable, so they don't run twice.
+func (m *Manager) cleanupOrphans(worker string) {
+	m.mu.Lock()
+	orphans := m.orphans[worker]
+	delete(m.orphans, worker)
+	m.mu.Unlock()
+
+	var remaining []uuid.UUID
+	for _, id := range orphans {
+		err := m.stopTask(worker, id)
+		if err != nil && err != errTaskNotFound {
+			log.Printf("Manager: Error tearing down orphaned task %s on worker %s: %v", id, worker, err)
+			remaining = append(remaining, id)
+			continue
+		}
+		log.Printf("Manager: Tore down orphaned task %s on worker %s", id, worker)
+	}
+	if len(remaining) > 0 {
+		m.mu.Lock()
+		m.orphans[worker] = append(m.orphans[worker], remaining...)
+		m.mu.Unlock()
+	}
+}
+
+// readyNodes returns the nodes that are ready to run tasks. m.mu must be
+// held.
+func (m *Manager) readyNodes() []*node.Node {
+	var nodes []*node.Node
+	for _, n := range m.WorkerNodes {
+		if n.State == node.Ready {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+func (m *Manager) UpdateNodeStats() {
+	for {
+		log.Println("Manager: Collecting stats from nodes")
+		m.updateNodeStats()
+		log.Printf("Manager: Sleeping for %v", m.NodeStatsInterval)
+		time.Sleep(m.NodeStatsInterval)
+	}
+}
+
+func (m *Manager) updateNodeStats() {
+	for _, c := range m.GetNodes() {
+		s, err := c.GetStats()
+		if err != nil {
+			log.Printf("Manager: Error getting stats from node %s: %v", c.Name, err)
+			continue
+		}
+
+		m.mu.Lock()
+		n := m.getNode(c.Name)
+		if n == nil {
+			m.mu.Unlock()
+			continue
+		}
+		m.markSeen(n, *s)
+		log.Printf("Manager: Node %s has %d cores, %d bytes memory (%d allocated), %d bytes disk (%d allocated), %d tasks",
+			n.Name, n.Cores, n.Memory, n.MemoryAllocated, n.Disk, n.DiskAllocated, n.TaskCounts)
+		m.mu.Unlock()
+	}
+}
+
+// GetNodes returns copies of the nodes known to the manager.
+func (m *Manager) GetNodes() []*node.Node {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	nodes := []*node.Node{}
+	for _, n := range m.WorkerNodes {
+		c := *n
+		nodes = append(nodes, &c)
+	}
+	return nodes
+}
+
+// GetNode returns a copy of the named node.
+func (m *Manager) GetNode(name string) (node.Node, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := m.getNode(name)
+	if n == nil {
+		return node.Node{}, false
+	}
+	return *n, true
+}
+
+// getNode returns the named node or nil. m.mu must be held.
+func (m *Manager) getNode(name string) *node.Node {
+	for _, n := range m.WorkerNodes {
+		if n.Name == name {
+			return n
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/araminian/cube/task"
+	"github.com/google/uuid"
+)
+
+func (m *Manager) RestartTasks() {
+	for {
+		log.Println("Manager: Checking for failed tasks to restart")
+		m.restartTasks()
+		log.Printf("Manager: Sleeping for %v", m.RestartInterval)
+		time.Sleep(m.RestartInterval)
+	}
+}
+
+// restartTasks puts failed tasks with restarts left back on the pending
+// queue once their backoff has elapsed, so they get placed again.
+func (m *Manager) restartTasks() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tasks, err := m.TaskDb.List()
+	if err != nil {
+		log.Printf("Manager: Error listing tasks: %v", err)
+		return
+	}
+
+	for _, t := range tasks {
+		if t.State != task.Failed {
+			continue
+		}
+
+		maxRestarts := m.maxRestarts(t)
+		if t.RestartCount >= maxRestarts {
+			continue
+		}
+
+		backoff := m.restartBackoff(t.RestartCount)
+		if time.Since(t.FinishTime) < backoff {
+			continue
+		}
+
+		if worker, ok := m.TaskWorkerMap[t.ID]; ok {
+			m.unassignTask(t, worker)
+		}
+
+		t.RestartCount++
+		t.State = task.Pending
+		if t.Mode == task.ModeJob {
+			t.Reason = fmt.Sprintf("retrying job after exit code %d (retry %d of %d)", t.ExitCode, t.RestartCount, maxRestarts)
+		} else {
+			t.Reason = fmt.Sprintf("restarting after failure (restart %d of %d)", t.RestartCount, maxRestarts)
+		}
+		log.Printf("Manager: Restarting task %s after %v: %s", t.ID, backoff, t.Reason)
+		m.saveTask(t)
+
+		retry := t
+		retry.State = task.Scheduled
+		retry.ContainerID = ""
+		retry.Health = task.HealthUnknown
+		m.AddTask(task.TaskEvent{
+			ID:        uuid.New(),
+			State:     task.Scheduled,
+			Timestamp: time.Now(),
+			Task:      retry,
+		})
+	}
+}
+
+// maxRestarts returns how often t is restarted after it failed.
+func (m *Manager) maxRestarts(t task.Task) int {
+	if t.MaxRestarts == 0 && t.Mode != task.ModeJob {
+		return m.MaxRestarts
+	}
+	return t.MaxRestarts
+}
+
+// isFinished reports whether t is done for good, i.e. it completed or it
+// failed and will not be restarted.
+func (m *Manager) isFinished(t task.Task) bool {
+	return t.State == task.Completed || (t.State == task.Failed && t.RestartCount >= m.maxRestarts(t))
+}
+
+// restartBackoff returns how long to wait before restarting a task that
+// has already been restarted count times.
+func (m *Manager) restartBackoff(count int) time.Duration {
+	backoff := m.RestartBackoff
+	for i := 0; i < count; i++ {
+		backoff *= 2
+		if backoff >= m.MaxRestartBackoff {
+			return m.MaxRestartBackoff
+		}
+	}
+	return backoff
+}
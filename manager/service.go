@@ -0,0 +1,467 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/araminian/cube/service"
+	"github.com/araminian/cube/task"
+	"github.com/google/uuid"
+)
+
+var errServiceNotFound = errors.New("service not found")
+
+// CreateService validates s, adds it to the manager and starts its tasks.
+func (m *Manager) CreateService(s service.Service) (service.Service, error) {
+	err := s.Validate()
+	if err != nil {
+		return service.Service{}, err
+	}
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	s.CreateTime = time.Now()
+	s.Revisions = nil
+	s.AddRevision(s.Task)
+	s.UpdateStatus = service.UpdateStatus{}
+	s.RunningReplicas = 0
+	s.Tasks = nil
+
+	m.serviceMu.Lock()
+	defer m.serviceMu.Unlock()
+	if _, err := m.ServiceDb.Get(s.ID.String()); err == nil {
+		return service.Service{}, fmt.Errorf("service %s already exists", s.ID)
+	}
+	log.Printf("Manager: Created service %s (%s) with %d replicas", s.Name, s.ID, s.Replicas)
+
+	m.reconcileService(&s, nil)
+	err = m.ServiceDb.Put(s.ID.String(), s)
+	if err != nil {
+		return service.Service{}, err
+	}
+	return m.serviceStatus(s), nil
+}
+
+// ScaleService changes the number of replicas of the service with the given
+// id and starts or stops tasks accordingly.
+func (m *Manager) ScaleService(id uuid.UUID, replicas int) (service.Service, error) {
+	return m.UpdateService(id, &replicas, nil, nil)
+}
+
+// UpdateService changes the number of replicas of the service with the
+// given id, if replicas is not nil, how its tasks are updated, if cfg is
+// not nil, and starts a rolling update to t, if t is not nil. Updating a
+// paused update to its current task resumes it. All changes are checked
+// before any is made, so either all or none of them are made.
+func (m *Manager) UpdateService(id uuid.UUID, replicas *int, t *task.Task, cfg *service.UpdateConfig) (service.Service, error) {
+	if replicas != nil && *replicas < 0 {
+		return service.Service{}, fmt.Errorf("replicas must not be negative")
+	}
+	if cfg != nil {
+		if err := cfg.Validate(); err != nil {
+			return service.Service{}, err
+		}
+	}
+	if t != nil {
+		if err := service.ValidateTask(*t); err != nil {
+			return service.Service{}, err
+		}
+	}
+
+	return m.changeService(id, func(s *service.Service) error {
+		if replicas != nil {
+			log.Printf("Manager: Scaling service %s from %d to %d replicas", s.Name, s.Replicas, *replicas)
+			s.Replicas = *replicas
+		}
+		if cfg != nil {
+			s.UpdateConfig = *cfg
+		}
+		if t == nil {
+			return nil
+		}
+		if reflect.DeepEqual(*t, s.Task) {
+			if s.UpdateStatus.State == service.Paused {
+				m.resumeUpdate(s)
+			}
+			return nil
+		}
+		m.startUpdate(s, *t, service.Updating, "")
+		return nil
+	})
+}
+
+// RollbackService starts a rolling update of the service with the given id
+// back to the task of the given revision. Revision 0 rolls back to the
+// revision the last update started from.
+func (m *Manager) RollbackService(id uuid.UUID, revision int) (service.Service, error) {
+	return m.changeService(id, func(s *service.Service) error {
+		if revision == 0 {
+			revision = s.UpdateStatus.PreviousRevision
+		}
+		r, ok := s.GetRevision(revision)
+		if !ok {
+			return fmt.Errorf("service %s has no revision %d", s.Name, revision)
+		}
+		if revision == s.Revision {
+			return fmt.Errorf("service %s already is at revision %d", s.Name, revision)
+		}
+		m.startUpdate(s, r.Task, service.RollingBack, fmt.Sprintf("rolling back to revision %d", revision))
+		return nil
+	})
+}
+
+// changeService applies fn to the service with the given id, saves it and
+// starts or stops tasks accordingly.
+func (m *Manager) changeService(id uuid.UUID, fn func(*service.Service) error) (service.Service, error) {
+	m.serviceMu.Lock()
+	defer m.serviceMu.Unlock()
+	s, err := m.ServiceDb.Get(id.String())
+	if err != nil {
+		return service.Service{}, errServiceNotFound
+	}
+	err = fn(&s)
+	if err != nil {
+		return service.Service{}, err
+	}
+
+	m.reconcileService(&s, m.serviceTasks(s.ID))
+	err = m.ServiceDb.Put(s.ID.String(), s)
+	if err != nil {
+		return service.Service{}, err
+	}
+	return m.serviceStatus(s), nil
+}
+
+// resumeUpdate resumes the paused update of s. The tasks that made it
+// pause are replaced by new ones.
+func (m *Manager) resumeUpdate(s *service.Service) {
+	log.Printf("Manager: Resuming update of service %s to revision %d", s.Name, s.Revision)
+	for _, t := range m.serviceTasks(s.ID) {
+		if t.Revision == s.Revision && m.failedDuringUpdate(s, t) {
+			m.StopTask(t)
+		}
+	}
+	s.UpdateStatus.State = service.Updating
+	s.UpdateStatus.Message = ""
+	s.UpdateStatus.StartTime = time.Now()
+}
+
+// startUpdate makes t the task of a new revision of s and starts replacing
+// the tasks of s with tasks of that revision.
+func (m *Manager) startUpdate(s *service.Service, t task.Task, state service.UpdateState, message string) {
+	previous := s.Revision
+	revision := s.AddRevision(t)
+	s.UpdateStatus = service.UpdateStatus{
+		State:            state,
+		PreviousRevision: previous,
+		Message:          message,
+		StartTime:        time.Now(),
+	}
+	log.Printf("Manager: Updating service %s from revision %d to %d", s.Name, previous, revision)
+}
+
+// DeleteService removes the service with the given id and stops its tasks.
+// It reports false when there is no such service.
+func (m *Manager) DeleteService(id uuid.UUID) bool {
+	m.serviceMu.Lock()
+	defer m.serviceMu.Unlock()
+	s, err := m.ServiceDb.Get(id.String())
+	if err != nil {
+		return false
+	}
+	err = m.ServiceDb.Delete(id.String())
+	if err != nil {
+		log.Printf("Manager: Error deleting service %s: %v", id, err)
+		return false
+	}
+
+	for _, t := range m.liveTasks(m.serviceTasks(id)) {
+		m.StopTask(t)
+	}
+	log.Printf("Manager: Deleted service %s", s.Name)
+	return true
+}
+
+func (m *Manager) GetService(id uuid.UUID) (service.Service, bool) {
+	s, err := m.ServiceDb.Get(id.String())
+	if err != nil {
+		return service.Service{}, false
+	}
+	return m.serviceStatus(s), true
+}
+
+func (m *Manager) GetServices() []service.Service {
+	services, err := m.ServiceDb.List()
+	if err != nil {
+		log.Printf("Manager: Error listing services: %v", err)
+		return []service.Service{}
+	}
+	for i, s := range services {
+		services[i] = m.serviceStatus(s)
+	}
+	return services
+}
+
+func (m *Manager) ReconcileServices() {
+	for {
+		m.reconcileServices()
+		time.Sleep(m.ServiceInterval)
+	}
+}
+
+// reconcileServices starts or stops tasks until every service has as many
+// live tasks of its current revision as it wants replicas.
+func (m *Manager) reconcileServices() {
+	m.serviceMu.Lock()
+	defer m.serviceMu.Unlock()
+
+	services, err := m.ServiceDb.List()
+	if err != nil {
+		log.Printf("Manager: Error listing services: %v", err)
+		return
+	}
+
+	tasks := make(map[uuid.UUID][]task.Task)
+	for _, t := range m.GetTasks() {
+		if t.Service != uuid.Nil {
+			tasks[t.Service] = append(tasks[t.Service], t)
+		}
+	}
+	for _, s := range services {
+		if !m.reconcileService(&s, tasks[s.ID]) {
+			continue
+		}
+		err := m.ServiceDb.Put(s.ID.String(), s)
+		if err != nil {
+			log.Printf("Manager: Error saving service %s: %v", s.ID, err)
+		}
+	}
+}
+
+// reconcileService starts or stops tasks of s, whose tasks are currently
+// tasks, until it has as many live tasks of its current revision as it
+// wants replicas. While s is updated, tasks of older revisions are
+// replaced step by step. It reports whether the update status of s
+// changed. m.serviceMu must be held.
+func (m *Manager) reconcileService(s *service.Service, tasks []task.Task) bool {
+	var current, old []task.Task
+	for _, t := range tasks {
+		if t.Revision == s.Revision {
+			current = append(current, t)
+		} else {
+			old = append(old, t)
+		}
+	}
+
+	changed := false
+	if s.UpdateStatus.Active() {
+		if t, ok := m.failedUpdateTask(s, current); ok {
+			m.failUpdate(s, t)
+			// A rollback has a new revision, so all tasks are old now.
+			m.reconcileService(s, tasks)
+			return true
+		}
+	}
+	if s.UpdateStatus.State == service.Paused {
+		return changed
+	}
+
+	live := m.liveTasks(current)
+	old = m.liveTasks(old)
+	if len(old) == 0 {
+		m.scaleService(s, live)
+		if s.UpdateStatus.Active() && m.updated(s, live) {
+			m.finishUpdate(s)
+			changed = true
+		}
+		return changed
+	}
+
+	m.rollTasks(s, live, old)
+	return changed
+}
+
+// scaleService starts or stops tasks of the current revision of s, whose
+// live tasks are live, until there are as many as s wants replicas.
+func (m *Manager) scaleService(s *service.Service, live []task.Task) {
+	for i := len(live); i < s.Replicas; i++ {
+		log.Printf("Manager: Starting task of service %s (%d of %d replicas)", s.Name, i+1, s.Replicas)
+		m.startServiceTask(s)
+	}
+
+	if len(live) <= s.Replicas {
+		return
+	}
+	// Stop the tasks that are not running yet first, then the newest.
+	sort.Slice(live, func(i, j int) bool {
+		ri, rj := live[i].State == task.Running, live[j].State == task.Running
+		if ri != rj {
+			return !ri
+		}
+		return live[i].StartTime.After(live[j].StartTime)
+	})
+	for _, t := range live[:len(live)-s.Replicas] {
+		log.Printf("Manager: Stopping task %s of service %s to scale it down to %d replicas", t.Name, s.Name, s.Replicas)
+		m.StopTask(t)
+	}
+}
+
+// rollTasks does one step of a rolling update of s: it starts tasks of the
+// current revision as far as the surge allows and stops tasks of older
+// revisions as far as the tasks that are ready allow.
+func (m *Manager) rollTasks(s *service.Service, current, old []task.Task) {
+	surge, unavailable := s.UpdateConfig.Limits()
+
+	start := min(s.Replicas-len(current), s.Replicas+surge-len(current)-len(old))
+	for i := 0; i < start; i++ {
+		log.Printf("Manager: Starting task of revision %d of service %s (%d of %d replicas)", s.Revision, s.Name, len(current)+i+1, s.Replicas)
+		m.startServiceTask(s)
+	}
+
+	ready := 0
+	for _, t := range current {
+		if isReady(t) {
+			ready++
+		}
+	}
+	var running []task.Task
+	for _, t := range old {
+		if t.State == task.Running {
+			running = append(running, t)
+			continue
+		}
+		// Old tasks that are not running serve nothing, replacing them
+		// makes nothing unavailable.
+		log.Printf("Manager: Stopping task %s of service %s, it is not running", t.Name, s.Name)
+		m.StopTask(t)
+	}
+
+	stop := min(len(running), ready+len(running)-(s.Replicas-unavailable))
+	sort.Slice(running, func(i, j int) bool {
+		return running[i].StartTime.Before(running[j].StartTime)
+	})
+	for _, t := range running[:max(stop, 0)] {
+		log.Printf("Manager: Stopping task %s of revision %d of service %s, %d tasks of revision %d are ready", t.Name, t.Revision, s.Name, ready, s.Revision)
+		m.StopTask(t)
+	}
+}
+
+// failedUpdateTask returns a task of the current revision of s that failed
+// since the update of s started, if any.
+func (m *Manager) failedUpdateTask(s *service.Service, current []task.Task) (task.Task, bool) {
+	for _, t := range current {
+		if m.failedDuringUpdate(s, t) {
+			return t, true
+		}
+	}
+	return task.Task{}, false
+}
+
+// failedDuringUpdate reports whether t failed since the update of s
+// started. Failed tasks that were restarted are back to Scheduled or
+// Running but counted the restart.
+func (m *Manager) failedDuringUpdate(s *service.Service, t task.Task) bool {
+	if m.isFinished(t) {
+		return t.State == task.Failed && t.FinishTime.After(s.UpdateStatus.StartTime)
+	}
+	return !m.isStopping(t) && (t.State == task.Failed || t.RestartCount > 0)
+}
+
+// failUpdate pauses the update of s or rolls it back after t failed.
+// Failed rollbacks are always paused.
+func (m *Manager) failUpdate(s *service.Service, t task.Task) {
+	message := fmt.Sprintf("task %s of revision %d failed: %s", t.Name, s.Revision, t.Reason)
+	previous, ok := s.GetRevision(s.UpdateStatus.PreviousRevision)
+	if s.UpdateConfig.FailureAction == service.Pause || s.UpdateStatus.State == service.RollingBack || !ok {
+		log.Printf("Manager: Pausing update of service %s: %s", s.Name, message)
+		s.UpdateStatus.State = service.Paused
+		s.UpdateStatus.Message = message
+		return
+	}
+
+	log.Printf("Manager: Rolling back update of service %s to revision %d: %s", s.Name, previous.Number, message)
+	m.startUpdate(s, previous.Task, service.RollingBack, fmt.Sprintf("rolled back to revision %d after %s", previous.Number, message))
+}
+
+// updated reports whether all tasks of s, whose live tasks of the current
+// revision are live, are ready.
+func (m *Manager) updated(s *service.Service, live []task.Task) bool {
+	ready := 0
+	for _, t := range live {
+		if isReady(t) {
+			ready++
+		}
+	}
+	return ready >= s.Replicas
+}
+
+func (m *Manager) finishUpdate(s *service.Service) {
+	if s.UpdateStatus.State == service.RollingBack {
+		s.UpdateStatus.State = service.RolledBack
+	} else {
+		s.UpdateStatus.State = service.Completed
+	}
+	s.UpdateStatus.FinishTime = time.Now()
+	log.Printf("Manager: Update of service %s to revision %d is %s", s.Name, s.Revision, s.UpdateStatus.State)
+}
+
+// startServiceTask submits a new task of the current revision of s.
+func (m *Manager) startServiceTask(s *service.Service) {
+	t := s.Task
+	t.ID = uuid.New()
+	t.Name = fmt.Sprintf("%s-%s", s.Name, t.ID.String()[:8])
+	t.State = task.Scheduled
+	t.Service = s.ID
+	t.Revision = s.Revision
+	m.SubmitTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Scheduled,
+		Timestamp: time.Now(),
+		Task:      t,
+	})
+}
+
+// isReady reports whether t serves, i.e. it is running and, if it has a
+// health check, healthy.
+func isReady(t task.Task) bool {
+	return t.State == task.Running && (t.HealthCheck == nil || t.Health == task.HealthHealthy)
+}
+
+// liveTasks returns the tasks that run or will run, i.e. that neither
+// finished for good nor are being stopped.
+func (m *Manager) liveTasks(tasks []task.Task) []task.Task {
+	var live []task.Task
+	for _, t := range tasks {
+		if !m.isFinished(t) && !m.isStopping(t) {
+			live = append(live, t)
+		}
+	}
+	return live
+}
+
+// serviceTasks returns the tasks started for the service with the given id.
+func (m *Manager) serviceTasks(id uuid.UUID) []task.Task {
+	var tasks []task.Task
+	for _, t := range m.GetTasks() {
+		if t.Service == id {
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks
+}
+
+// serviceStatus returns s with its live tasks filled in.
+func (m *Manager) serviceStatus(s service.Service) service.Service {
+	s.Tasks = m.liveTasks(m.serviceTasks(s.ID))
+	s.RunningReplicas = 0
+	for _, t := range s.Tasks {
+		if t.State == task.Running {
+			s.RunningReplicas++
+		}
+	}
+	return s
+}
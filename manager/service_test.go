@@ -0,0 +1,222 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/araminian/cube/service"
+	"github.com/araminian/cube/task"
+	"github.com/google/uuid"
+)
+
+func (c *testCluster) waitForUpdate(t *testing.T, id uuid.UUID, state service.UpdateState) service.Service {
+	t.Helper()
+	var s service.Service
+	waitFor(t, "service update to be "+string(state), func() bool {
+		s, _ = c.Manager.GetService(id)
+		return s.UpdateStatus.State == state
+	})
+	return s
+}
+
+// waitForReplicas waits until the service with the given id runs all its
+// replicas from image and no other tasks.
+func (c *testCluster) waitForReplicas(t *testing.T, id uuid.UUID, image string) service.Service {
+	t.Helper()
+	var s service.Service
+	waitFor(t, "service to run "+image, func() bool {
+		s, _ = c.Manager.GetService(id)
+		if s.RunningReplicas != s.Replicas || len(s.Tasks) != s.Replicas {
+			return false
+		}
+		for _, tk := range s.Tasks {
+			if tk.Image != image || tk.Revision != s.Revision {
+				return false
+			}
+		}
+		return true
+	})
+	return s
+}
+
+func (c *testCluster) createService(t *testing.T, replicas int, cfg service.UpdateConfig) service.Service {
+	t.Helper()
+	s, err := c.Manager.CreateService(service.Service{
+		Name:         "web",
+		Task:         task.Task{Image: "v1"},
+		Replicas:     replicas,
+		UpdateConfig: cfg,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.waitForReplicas(t, s.ID, "v1")
+}
+
+func (c *testCluster) updateImage(t *testing.T, id uuid.UUID, image string) {
+	t.Helper()
+	if _, err := c.Manager.UpdateService(id, nil, &task.Task{Image: image}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServiceRollingUpdate(t *testing.T) {
+	tests := []struct {
+		surge, unavailable int
+	}{
+		{0, 0},
+		{1, 0},
+		{0, 1},
+		{2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("surge %d unavailable %d", tt.surge, tt.unavailable), func(t *testing.T) {
+			c := newTestCluster(t, 2)
+			for _, rt := range c.Runtimes {
+				rt.Default = task.FakeBehavior{StartDelay: 5 * time.Millisecond}
+			}
+			s := c.createService(t, 3, service.UpdateConfig{MaxSurge: tt.surge, MaxUnavailable: tt.unavailable})
+			surge, unavailable := s.UpdateConfig.Limits()
+
+			// Watch the service while it is updated.
+			done := make(chan struct{})
+			var watch sync.WaitGroup
+			watch.Add(1)
+			go func() {
+				defer watch.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					s, _ := c.Manager.GetService(s.ID)
+					if len(s.Tasks) > s.Replicas+surge {
+						t.Errorf("%d tasks are live, more than %d replicas and a surge of %d", len(s.Tasks), s.Replicas, surge)
+						return
+					}
+					if s.RunningReplicas < s.Replicas-unavailable {
+						t.Errorf("%d tasks are running, fewer than %d replicas less %d unavailable", s.RunningReplicas, s.Replicas, unavailable)
+						return
+					}
+					time.Sleep(time.Millisecond)
+				}
+			}()
+
+			c.updateImage(t, s.ID, "v2")
+			s = c.waitForUpdate(t, s.ID, service.Completed)
+			close(done)
+			watch.Wait()
+
+			if s.Revision != 2 || s.UpdateStatus.PreviousRevision != 1 {
+				t.Errorf("service is at revision %d from %d, want 2 from 1", s.Revision, s.UpdateStatus.PreviousRevision)
+			}
+			c.waitForReplicas(t, s.ID, "v2")
+		})
+	}
+}
+
+func TestServiceUpdateRollsBack(t *testing.T) {
+	c := newTestCluster(t, 2)
+	for _, rt := range c.Runtimes {
+		rt.SetBehavior("bad", task.FakeBehavior{CrashAfter: 20 * time.Millisecond, ExitCode: 1})
+	}
+	s := c.createService(t, 3, service.UpdateConfig{})
+
+	c.updateImage(t, s.ID, "bad")
+	s = c.waitForUpdate(t, s.ID, service.RolledBack)
+	// The rollback is a new revision with the task of the first one.
+	if s.Revision != 3 || s.Task.Image != "v1" {
+		t.Errorf("service is at revision %d running %s, want revision 3 running v1", s.Revision, s.Task.Image)
+	}
+	c.waitForReplicas(t, s.ID, "v1")
+}
+
+func TestServiceUpdatePauses(t *testing.T) {
+	c := newTestCluster(t, 2)
+	for _, rt := range c.Runtimes {
+		rt.SetBehavior("bad", task.FakeBehavior{CrashAfter: 20 * time.Millisecond, ExitCode: 1})
+	}
+	s := c.createService(t, 3, service.UpdateConfig{FailureAction: service.Pause})
+
+	c.updateImage(t, s.ID, "bad")
+	s = c.waitForUpdate(t, s.ID, service.Paused)
+	if s.Revision != 2 {
+		t.Errorf("paused service is at revision %d, want 2", s.Revision)
+	}
+	// A paused update replaces no more tasks.
+	running := func() int {
+		s, _ := c.Manager.GetService(s.ID)
+		n := 0
+		for _, tk := range s.Tasks {
+			if tk.Image == "v1" && tk.State == task.Running {
+				n++
+			}
+		}
+		return n
+	}
+	before := running()
+	time.Sleep(5 * testInterval)
+	if after := running(); before == 0 || after != before {
+		t.Errorf("paused service ran %d tasks of revision 1, then %d", before, after)
+	}
+
+	if _, err := c.Manager.RollbackService(s.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	s = c.waitForUpdate(t, s.ID, service.RolledBack)
+	if s.Revision != 3 || s.Task.Image != "v1" {
+		t.Errorf("service is at revision %d running %s, want revision 3 running v1", s.Revision, s.Task.Image)
+	}
+	c.waitForReplicas(t, s.ID, "v1")
+}
+
+func TestServiceRollbackToRevision(t *testing.T) {
+	c := newTestCluster(t, 2)
+	s := c.createService(t, 2, service.UpdateConfig{})
+	for _, image := range []string{"v2", "v3"} {
+		c.updateImage(t, s.ID, image)
+		c.waitForUpdate(t, s.ID, service.Completed)
+	}
+
+	if _, err := c.Manager.RollbackService(s.ID, 3); err == nil {
+		t.Error("rolling back to the current revision succeeded")
+	}
+	if _, err := c.Manager.RollbackService(s.ID, 7); err == nil {
+		t.Error("rolling back to an unknown revision succeeded")
+	}
+
+	if _, err := c.Manager.RollbackService(s.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	s = c.waitForUpdate(t, s.ID, service.RolledBack)
+	if s.Revision != 4 || s.UpdateStatus.PreviousRevision != 3 {
+		t.Errorf("service is at revision %d from %d, want 4 from 3", s.Revision, s.UpdateStatus.PreviousRevision)
+	}
+	c.waitForReplicas(t, s.ID, "v1")
+}
+
+func TestUpdateServiceIsAllOrNothing(t *testing.T) {
+	c := newTestCluster(t, 1)
+	s := c.createService(t, 1, service.UpdateConfig{})
+
+	replicas := 3
+	data, _ := json.Marshal(ServicePatch{Replicas: &replicas, Task: &task.Task{}})
+	req, _ := http.NewRequest(http.MethodPatch, c.Server.URL+"/services/"+s.ID.String(), bytes.NewReader(data))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("patching with a task without image: status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	if s, _ = c.Manager.GetService(s.ID); s.Replicas != 1 || s.Revision != 1 {
+		t.Errorf("service has %d replicas at revision %d after a rejected patch, want 1 at 1", s.Replicas, s.Revision)
+	}
+}
@@ -0,0 +1,312 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/araminian/cube/task"
+	"github.com/araminian/cube/workflow"
+	"github.com/google/uuid"
+)
+
+// CreateWorkflow validates wf, records its tasks as pending and starts the
+// tasks that depend on no other task.
+func (m *Manager) CreateWorkflow(wf workflow.Workflow) (workflow.Workflow, error) {
+	err := wf.Validate()
+	if err != nil {
+		return workflow.Workflow{}, err
+	}
+	if wf.ID == uuid.Nil {
+		wf.ID = uuid.New()
+	}
+	wf.State = workflow.Pending
+	wf.CreateTime = time.Now()
+	wf.Released = make(map[uuid.UUID]bool)
+
+	// Task names are prefixed with the workflow, like those of services
+	// and cron jobs, so that the containers of two workflows with the same
+	// steps don't collide. Dependencies refer to the prefixed names.
+	prefix := wf.ID.String()[:8] + "-"
+	for i := range wf.Tasks {
+		t := &wf.Tasks[i]
+		if t.ID == uuid.Nil {
+			t.ID = uuid.New()
+		}
+		if _, ok := m.GetTask(t.ID); ok {
+			return workflow.Workflow{}, fmt.Errorf("task %s already exists", t.ID)
+		}
+		t.Workflow = wf.ID
+		t.Mode = task.ModeJob
+		t.State = task.Pending
+		t.Name = prefix + t.Name
+		if len(t.DependsOn) > 0 {
+			t.Reason = fmt.Sprintf("waiting for %s", strings.Join(t.DependsOn, ", "))
+			deps := make([]string, len(t.DependsOn))
+			for j, dep := range t.DependsOn {
+				deps[j] = prefix + dep
+			}
+			t.DependsOn = deps
+		}
+	}
+
+	m.workflowMu.Lock()
+	defer m.workflowMu.Unlock()
+	if _, err := m.WorkflowDb.Get(wf.ID.String()); err == nil {
+		return workflow.Workflow{}, fmt.Errorf("workflow %s already exists", wf.ID)
+	}
+
+	m.mu.Lock()
+	for _, t := range wf.Tasks {
+		m.saveTask(t)
+	}
+	m.mu.Unlock()
+
+	log.Printf("Manager: Created workflow %s (%s) with %d tasks", wf.Name, wf.ID, len(wf.Tasks))
+	m.releaseTasks(&wf)
+	err = m.WorkflowDb.Put(wf.ID.String(), wf)
+	if err != nil {
+		return workflow.Workflow{}, err
+	}
+	return m.workflowStatus(wf), nil
+}
+
+// CancelWorkflow stops the running tasks of the workflow with the given id
+// and fails the ones that were not started yet. It reports false when there
+// is no such workflow.
+func (m *Manager) CancelWorkflow(id uuid.UUID) bool {
+	m.workflowMu.Lock()
+	defer m.workflowMu.Unlock()
+
+	wf, err := m.WorkflowDb.Get(id.String())
+	if err != nil {
+		return false
+	}
+
+	for _, t := range m.workflowTasks(wf) {
+		if wf.Released[t.ID] {
+			if !m.isFinished(t) {
+				m.StopTask(t)
+			}
+			continue
+		}
+		m.failTask(t, "workflow was cancelled")
+		wf.Released[t.ID] = true
+	}
+	log.Printf("Manager: Cancelled workflow %s", id)
+
+	err = m.WorkflowDb.Put(wf.ID.String(), wf)
+	if err != nil {
+		log.Printf("Manager: Error saving workflow %s: %v", wf.ID, err)
+	}
+	return true
+}
+
+// GetWorkflow returns the workflow with the given id along with the
+// current state of its tasks.
+func (m *Manager) GetWorkflow(id uuid.UUID) (workflow.Workflow, bool) {
+	wf, err := m.WorkflowDb.Get(id.String())
+	if err != nil {
+		return workflow.Workflow{}, false
+	}
+	return m.workflowStatus(wf), true
+}
+
+func (m *Manager) GetWorkflows() []workflow.Workflow {
+	workflows, err := m.WorkflowDb.List()
+	if err != nil {
+		log.Printf("Manager: Error listing workflows: %v", err)
+		return []workflow.Workflow{}
+	}
+	for i, wf := range workflows {
+		workflows[i] = m.workflowStatus(wf)
+	}
+	return workflows
+}
+
+// RunWorkflows starts the tasks of workflows as their dependencies
+// complete. It first requeues the released tasks the manager had not placed
+// yet when it last stopped.
+func (m *Manager) RunWorkflows() {
+	m.requeueWorkflowTasks()
+	for {
+		m.runWorkflows()
+		time.Sleep(m.WorkflowInterval)
+	}
+}
+
+// requeueWorkflowTasks queues the released tasks of unfinished workflows
+// that are pending. restore leaves them alone
+// as it can't tell them from tasks waiting for their dependencies.
+func (m *Manager) requeueWorkflowTasks() {
+	m.workflowMu.Lock()
+	defer m.workflowMu.Unlock()
+
+	workflows, err := m.WorkflowDb.List()
+	if err != nil {
+		log.Printf("Manager: Error listing workflows: %v", err)
+		return
+	}
+	for _, wf := range workflows {
+		if wf.State == workflow.Completed || wf.State == workflow.Failed {
+			continue
+		}
+		for _, t := range m.workflowTasks(wf) {
+			if !wf.Released[t.ID] || t.State != task.Pending {
+				continue
+			}
+			log.Printf("Manager: Requeueing task %s of workflow %s", t.Name, wf.Name)
+			t.State = task.Scheduled
+			m.AddTask(task.TaskEvent{
+				ID:        uuid.New(),
+				State:     task.Scheduled,
+				Timestamp: time.Now(),
+				Task:      t,
+			})
+		}
+	}
+}
+
+// runWorkflows starts the tasks whose dependencies have completed and fails
+// the ones with a failed dependency.
+func (m *Manager) runWorkflows() {
+	m.workflowMu.Lock()
+	defer m.workflowMu.Unlock()
+
+	workflows, err := m.WorkflowDb.List()
+	if err != nil {
+		log.Printf("Manager: Error listing workflows: %v", err)
+		return
+	}
+	for _, wf := range workflows {
+		if wf.State == workflow.Completed || wf.State == workflow.Failed {
+			continue
+		}
+
+		m.releaseTasks(&wf)
+		state := m.workflowStatus(wf).State
+		if state != wf.State {
+			log.Printf("Manager: Workflow %s is %s", wf.Name, state)
+		}
+		wf.State = state
+
+		err := m.WorkflowDb.Put(wf.ID.String(), wf)
+		if err != nil {
+			log.Printf("Manager: Error saving workflow %s: %v", wf.ID, err)
+		}
+	}
+}
+
+// releaseTasks submits the tasks of wf whose dependencies all completed
+// and fails the tasks that depend on a failed task. m.workflowMu must be
+// held.
+func (m *Manager) releaseTasks(wf *workflow.Workflow) {
+	order, err := wf.Order()
+	if err != nil {
+		log.Printf("Manager: Invalid workflow %s: %v", wf.Name, err)
+		return
+	}
+
+	tasks := make(map[string]task.Task)
+	for _, t := range m.workflowTasks(*wf) {
+		tasks[t.Name] = t
+	}
+
+	for _, name := range order {
+		t, ok := tasks[name]
+		if !ok || wf.Released[t.ID] {
+			continue
+		}
+
+		completed := true
+		failed := ""
+		for _, dep := range t.DependsOn {
+			d := tasks[dep]
+			switch {
+			case d.State == task.Completed:
+			case m.isFinished(d):
+				failed = dep
+			default:
+				completed = false
+			}
+		}
+
+		switch {
+		case failed != "":
+			log.Printf("Manager: Task %s of workflow %s fails because %s failed", name, wf.Name, failed)
+			tasks[name] = m.failTask(t, fmt.Sprintf("dependency %s failed", failed))
+			wf.Released[t.ID] = true
+		case completed:
+			log.Printf("Manager: Starting task %s of workflow %s", name, wf.Name)
+			t.State = task.Scheduled
+			t.Reason = ""
+			m.SubmitTask(task.TaskEvent{
+				ID:        uuid.New(),
+				State:     task.Scheduled,
+				Timestamp: time.Now(),
+				Task:      t,
+			})
+			wf.Released[t.ID] = true
+		}
+	}
+}
+
+// failTask marks t, which was never started, as failed for good.
+func (m *Manager) failTask(t task.Task, reason string) task.Task {
+	t.State = task.Failed
+	t.Reason = reason
+	t.FinishTime = time.Now()
+	// The task must not be restarted while its dependencies aren't met.
+	t.MaxRestarts = -1
+
+	m.mu.Lock()
+	m.saveTask(t)
+	m.mu.Unlock()
+	return t
+}
+
+// workflowTasks returns the current state of the tasks of wf.
+func (m *Manager) workflowTasks(wf workflow.Workflow) []task.Task {
+	var tasks []task.Task
+	for _, t := range wf.Tasks {
+		current, ok := m.GetTask(t.ID)
+		if !ok {
+			continue
+		}
+		tasks = append(tasks, current)
+	}
+	return tasks
+}
+
+// workflowStatus returns wf with the current state of its tasks and the
+// state of the workflow as a whole derived from them.
+func (m *Manager) workflowStatus(wf workflow.Workflow) workflow.Workflow {
+	wf.Tasks = m.workflowTasks(wf)
+
+	started, active, failed := false, false, false
+	for _, t := range wf.Tasks {
+		if t.State != task.Pending {
+			started = true
+		}
+		switch {
+		case t.State == task.Completed:
+		case m.isFinished(t):
+			failed = true
+		default:
+			active = true
+		}
+	}
+
+	switch {
+	case active && started:
+		wf.State = workflow.Running
+	case active:
+		wf.State = workflow.Pending
+	case failed:
+		wf.State = workflow.Failed
+	default:
+		wf.State = workflow.Completed
+	}
+	return wf
+}
@@ -0,0 +1,136 @@
+package manager
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/araminian/cube/store"
+	"github.com/araminian/cube/task"
+	"github.com/araminian/cube/workflow"
+	"github.com/google/uuid"
+)
+
+func step(name, image string, deps ...string) task.Task {
+	return task.Task{Name: name, Image: image, DependsOn: deps}
+}
+
+func (c *testCluster) waitForWorkflow(t *testing.T, id uuid.UUID, state workflow.State) workflow.Workflow {
+	t.Helper()
+	var wf workflow.Workflow
+	waitFor(t, "workflow to be "+string(state), func() bool {
+		wf, _ = c.Manager.GetWorkflow(id)
+		return wf.State == state
+	})
+	return wf
+}
+
+func workflowTask(t *testing.T, wf workflow.Workflow, name string) task.Task {
+	t.Helper()
+	for _, tk := range wf.Tasks {
+		if strings.HasSuffix(tk.Name, "-"+name) {
+			return tk
+		}
+	}
+	t.Fatalf("workflow has no task %s", name)
+	return task.Task{}
+}
+
+func TestWorkflowRunsInOrder(t *testing.T) {
+	c := newTestCluster(t, 2)
+	for _, rt := range c.Runtimes {
+		rt.SetBehavior("job", task.FakeBehavior{CrashAfter: 20 * time.Millisecond})
+	}
+
+	wf, err := c.Manager.CreateWorkflow(workflow.Workflow{
+		Name: "build",
+		Tasks: []task.Task{
+			step("test", "job", "build"),
+			step("build", "job", "fetch"),
+			step("fetch", "job"),
+			step("lint", "job", "fetch"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf = c.waitForWorkflow(t, wf.ID, workflow.Completed)
+
+	fetch := workflowTask(t, wf, "fetch")
+	for _, name := range []string{"build", "lint"} {
+		if tk := workflowTask(t, wf, name); tk.StartTime.Before(fetch.FinishTime) {
+			t.Errorf("%s started at %v, before fetch finished at %v", name, tk.StartTime, fetch.FinishTime)
+		}
+	}
+	build, test := workflowTask(t, wf, "build"), workflowTask(t, wf, "test")
+	if test.StartTime.Before(build.FinishTime) {
+		t.Errorf("test started at %v, before build finished at %v", test.StartTime, build.FinishTime)
+	}
+}
+
+func TestWorkflowFailurePropagates(t *testing.T) {
+	c := newTestCluster(t, 1)
+	c.Runtimes[0].SetBehavior("job", task.FakeBehavior{CrashAfter: 20 * time.Millisecond})
+	c.Runtimes[0].SetBehavior("fail", task.FakeBehavior{CrashAfter: 20 * time.Millisecond, ExitCode: 1})
+
+	wf, err := c.Manager.CreateWorkflow(workflow.Workflow{
+		Name: "deploy",
+		Tasks: []task.Task{
+			step("build", "fail"),
+			step("test", "job", "build"),
+			step("deploy", "job", "test"),
+			step("docs", "job"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wf = c.waitForWorkflow(t, wf.ID, workflow.Failed)
+
+	if tk := workflowTask(t, wf, "build"); tk.State != task.Failed || tk.ExitCode != 1 {
+		t.Errorf("build is %v with exit code %d, want Failed with 1", tk.State, tk.ExitCode)
+	}
+	for _, name := range []string{"test", "deploy"} {
+		tk := workflowTask(t, wf, name)
+		if tk.State != task.Failed || !strings.Contains(tk.Reason, "dependency") || !tk.StartTime.IsZero() {
+			t.Errorf("%s is %v because %q, started at %v, want Failed without starting", name, tk.State, tk.Reason, tk.StartTime)
+		}
+	}
+	if tk := workflowTask(t, wf, "docs"); tk.State != task.Completed {
+		t.Errorf("docs is %v, want Completed", tk.State)
+	}
+}
+
+func TestRestoreLeavesWaitingWorkflowTasks(t *testing.T) {
+	released := task.Task{ID: uuid.New(), Name: "build", Image: "job", State: task.Pending}
+	waiting := task.Task{ID: uuid.New(), Name: "test", Image: "job", State: task.Pending, DependsOn: []string{"build"}}
+	wf := workflow.Workflow{
+		ID:       uuid.New(),
+		Name:     "build",
+		State:    workflow.Running,
+		Tasks:    []task.Task{released, waiting},
+		Released: map[uuid.UUID]bool{released.ID: true},
+	}
+	single := task.Task{ID: uuid.New(), Name: "web", Image: "nginx", State: task.Pending}
+
+	tasks := store.NewMemoryStore[task.Task]()
+	for _, tk := range []*task.Task{&released, &waiting} {
+		tk.Workflow = wf.ID
+		tasks.Put(tk.ID.String(), *tk)
+	}
+	tasks.Put(single.ID.String(), single)
+
+	m, err := NewManager(nil, "roundrobin", tasks, store.NewMemoryStore[task.TaskEvent]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := m.Pending.Len(); n != 1 {
+		t.Fatalf("restore queued %d tasks, want only the one outside the workflow", n)
+	}
+
+	m.WorkflowDb.Put(wf.ID.String(), wf)
+	m.requeueWorkflowTasks()
+	if n := m.Pending.Len(); n != 2 {
+		t.Errorf("%d tasks are queued, want the released task of the workflow queued too", n)
+	}
+}
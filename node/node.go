@@ -1,13 +1,128 @@
 package node
 
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/araminian/cube/stats"
+)
+
+type State string
+
+const (
+	Ready       State = "Ready"
+	Unreachable State = "Unreachable"
+)
+
 type Node struct {
 	Name            string
 	Ip              string
+	Api             string
 	Cores           int
 	Memory          int
 	MemoryAllocated int
 	Disk            int
 	DiskAllocated   int
-	Role            string
-	TaskCounts      int
+	// HostPortsAllocated holds the host ports, e.g. "8080/tcp", bound by
+	// the tasks placed on the node.
+	HostPortsAllocated []string
+	Stats              stats.Stats
+	Role               string
+	TaskCounts         int
+	State              State
+	LastSeen           time.Time
+}
+
+func NewNode(name string, api string, role string) *Node {
+	return &Node{
+		Name:     name,
+		Api:      api,
+		Role:     role,
+		State:    Ready,
+		LastSeen: time.Now(),
+	}
+}
+
+// UpdateStats records stats reported by the worker running on the node and
+// updates the node's capacity from them.
+func (n *Node) UpdateStats(s stats.Stats) {
+	if s.MemStats != nil {
+		n.Memory = int(s.MemTotalKb() * 1024)
+	}
+	if s.DiskStats != nil {
+		n.Disk = int(s.DiskTotal())
+	}
+	if s.CpuCount > 0 {
+		n.Cores = s.CpuCount
+	}
+	n.Stats = s
+	n.LastSeen = time.Now()
+}
+
+// HostPortsFree reports whether none of the given host ports is bound by a
+// task on the node.
+func (n *Node) HostPortsFree(ports []string) bool {
+	for _, p := range ports {
+		if slices.Contains(n.HostPortsAllocated, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// AllocateHostPorts records ports as bound on the node. Like
+// ReleaseHostPorts it replaces HostPortsAllocated instead of modifying it,
+// so copies of the node are not affected.
+func (n *Node) AllocateHostPorts(ports []string) {
+	if len(ports) == 0 {
+		return
+	}
+	n.HostPortsAllocated = append(slices.Clone(n.HostPortsAllocated), ports...)
+}
+
+// ReleaseHostPorts records ports as no longer bound on the node.
+func (n *Node) ReleaseHostPorts(ports []string) {
+	if len(ports) == 0 {
+		return
+	}
+	// Each port is released once, in case it was allocated twice.
+	remaining := slices.Clone(ports)
+	var allocated []string
+	for _, p := range n.HostPortsAllocated {
+		if i := slices.Index(remaining, p); i >= 0 {
+			remaining = slices.Delete(remaining, i, i+1)
+			continue
+		}
+		allocated = append(allocated, p)
+	}
+	n.HostPortsAllocated = allocated
+}
+
+// GetStats fetches the stats of the worker running on the node.
+func (n *Node) GetStats() (*stats.Stats, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/stats", n.Api))
+	if err != nil {
+		log.Printf("Error connecting to %v: %v", n.Api, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error retrieving stats from %v: status %d", n.Api, resp.StatusCode)
+	}
+
+	var s stats.Stats
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	if err != nil {
+		log.Printf("Error decoding stats from %v: %v", n.Api, err)
+		return nil, err
+	}
+
+	n.UpdateStats(s)
+
+	return &n.Stats, nil
 }
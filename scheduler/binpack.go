@@ -0,0 +1,45 @@
+package scheduler
+
+import (
+	"github.com/araminian/cube/node"
+	"github.com/araminian/cube/task"
+)
+
+// BinPack places tasks on the node where they fit most tightly, based on the
+// memory and disk already allocated to tasks on each node. Nodes without
+// enough free capacity are not candidates, so a task that fits nowhere is
+// not placed at all.
+type BinPack struct {
+	Name string
+}
+
+func (b *BinPack) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	var candidates []*node.Node
+	for _, n := range nodes {
+		if fits(t, n) && portsFree(t, n) {
+			candidates = append(candidates, n)
+		}
+	}
+	return candidates
+}
+
+// Score is the fraction of memory and disk that would be left free on the
+// node after placing the task; the lower it is, the tighter the fit.
+func (b *BinPack) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	nodeScores := make(map[string]float64)
+	for _, n := range nodes {
+		var score float64
+		if n.Memory > 0 {
+			score += float64(n.Memory-n.MemoryAllocated-t.Memory) / float64(n.Memory)
+		}
+		if n.Disk > 0 {
+			score += float64(n.Disk-n.DiskAllocated-t.Disk) / float64(n.Disk)
+		}
+		nodeScores[n.Name] = score
+	}
+	return nodeScores
+}
+
+func (b *BinPack) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	return pickLowest(scores, candidates)
+}
@@ -0,0 +1,99 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/araminian/cube/node"
+	"github.com/araminian/cube/task"
+)
+
+func TestBinPack(t *testing.T) {
+	b := &BinPack{}
+	tests := []struct {
+		name  string
+		task  task.Task
+		nodes []*node.Node
+		want  string
+	}{
+		{
+			"tightest memory fit",
+			task.Task{Memory: gb},
+			[]*node.Node{
+				{Name: "a", Memory: 4 * gb, MemoryAllocated: gb},
+				{Name: "b", Memory: 4 * gb, MemoryAllocated: 2 * gb},
+				{Name: "c", Memory: 8 * gb, MemoryAllocated: 2 * gb},
+			},
+			"b",
+		},
+		{
+			"exact fit",
+			task.Task{Memory: 2 * gb},
+			[]*node.Node{{Name: "a", Memory: 8 * gb}, {Name: "b", Memory: 4 * gb, MemoryAllocated: 2 * gb}},
+			"b",
+		},
+		{
+			"memory and disk",
+			task.Task{Memory: gb, Disk: 10 * gb},
+			[]*node.Node{
+				{Name: "a", Memory: 4 * gb, MemoryAllocated: 2 * gb, Disk: 100 * gb},
+				{Name: "b", Memory: 4 * gb, MemoryAllocated: gb, Disk: 100 * gb, DiskAllocated: 80 * gb},
+			},
+			"b",
+		},
+		{
+			"not enough memory",
+			task.Task{Memory: 2 * gb},
+			[]*node.Node{{Name: "a", Memory: 4 * gb, MemoryAllocated: 3 * gb}, {Name: "b", Memory: 16 * gb}},
+			"b",
+		},
+		{
+			"not enough disk",
+			task.Task{Disk: 10 * gb},
+			[]*node.Node{{Name: "a", Disk: 20 * gb, DiskAllocated: 15 * gb}, {Name: "b", Disk: 100 * gb}},
+			"b",
+		},
+		{
+			"unknown capacity",
+			task.Task{Memory: gb},
+			[]*node.Node{{Name: "a"}, {Name: "b", Memory: 16 * gb}},
+			"b",
+		},
+		{
+			"port taken",
+			withPort(task.Task{Memory: gb}, "8080"),
+			[]*node.Node{
+				{Name: "a", Memory: 2 * gb, HostPortsAllocated: []string{"8080/tcp"}},
+				{Name: "b", Memory: 16 * gb, HostPortsAllocated: []string{"8080/udp"}},
+			},
+			"b",
+		},
+		{
+			"fits nowhere",
+			task.Task{Memory: 4 * gb},
+			[]*node.Node{{Name: "a", Memory: 4 * gb, MemoryAllocated: gb}, {Name: "b", Memory: 2 * gb}},
+			"",
+		},
+	}
+	for _, tt := range tests {
+		if got := schedule(b, tt.task, tt.nodes...); got != tt.want {
+			t.Errorf("%s: task went to %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBinPackScore(t *testing.T) {
+	b := &BinPack{}
+	nodes := []*node.Node{
+		{Name: "a", Memory: 4 * gb, MemoryAllocated: gb},
+		{Name: "b", Memory: 4 * gb, Disk: 100 * gb, DiskAllocated: 50 * gb},
+		{Name: "c"},
+	}
+	scores := b.Score(task.Task{Memory: gb, Disk: 25 * gb}, nodes)
+	// The fraction of memory and of disk left free, added up.
+	want := map[string]float64{"a": 0.5, "b": 0.75 + 0.25, "c": 0}
+	for name, score := range want {
+		if got, ok := scores[name]; !ok || got != score {
+			t.Errorf("score of %s is %v, want %v", name, got, score)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package scheduler
+
+import (
+	"math"
+
+	"github.com/araminian/cube/node"
+	"github.com/araminian/cube/task"
+)
+
+// LIEB is the base of the exponential cost function used by E-PVM.
+const LIEB = 1.53960071783900203869
+
+// Epvm implements the Enhanced Parallel Virtual Machine scheduling
+// algorithm: every resource has a cost that grows exponentially with its
+// utilization, and a task goes to the node where placing it increases the
+// total cost the least. This keeps small nodes from filling up as fast as
+// big ones. Nodes without enough free memory or disk are not candidates.
+type Epvm struct {
+	Name string
+}
+
+func (e *Epvm) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	var candidates []*node.Node
+	for _, n := range nodes {
+		if fits(t, n) && portsFree(t, n) {
+			candidates = append(candidates, n)
+		}
+	}
+	return candidates
+}
+
+func (e *Epvm) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	nodeScores := make(map[string]float64)
+	for _, n := range nodes {
+		cores := n.Cores
+		if cores <= 0 {
+			cores = 1
+		}
+
+		// Every task is assumed to keep one core busy.
+		cpuLoad := loadPerCore(n)
+		cpuCost := marginalCost(cpuLoad, cpuLoad+1/float64(cores))
+
+		var memCost float64
+		if n.Memory > 0 {
+			used := float64(memUsed(n) + n.MemoryAllocated)
+			memCost = marginalCost(
+				used/float64(n.Memory),
+				(used+float64(t.Memory))/float64(n.Memory),
+			)
+		}
+
+		nodeScores[n.Name] = cpuCost + memCost
+	}
+	return nodeScores
+}
+
+func (e *Epvm) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	return pickLowest(scores, candidates)
+}
+
+func marginalCost(before, after float64) float64 {
+	return math.Pow(LIEB, after) - math.Pow(LIEB, before)
+}
+
+// memUsed returns the memory in use on the node in bytes.
+func memUsed(n *node.Node) int {
+	if n.Stats.MemStats == nil {
+		return 0
+	}
+	return int(n.Stats.MemUsedKb() * 1024)
+}
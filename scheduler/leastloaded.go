@@ -0,0 +1,47 @@
+package scheduler
+
+import (
+	"github.com/araminian/cube/node"
+	"github.com/araminian/cube/task"
+)
+
+// LeastLoaded places tasks on the node running the fewest tasks, breaking
+// ties by the node's load average per core.
+type LeastLoaded struct {
+	Name string
+}
+
+func (l *LeastLoaded) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	var candidates []*node.Node
+	for _, n := range nodes {
+		if portsFree(t, n) {
+			candidates = append(candidates, n)
+		}
+	}
+	return candidates
+}
+
+func (l *LeastLoaded) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	nodeScores := make(map[string]float64)
+	for _, n := range nodes {
+		// The load per core is below 1 on a healthy node, so it only
+		// decides between nodes with the same task count.
+		nodeScores[n.Name] = float64(n.TaskCounts) + loadPerCore(n)/(1+loadPerCore(n))
+	}
+	return nodeScores
+}
+
+func (l *LeastLoaded) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	return pickLowest(scores, candidates)
+}
+
+func loadPerCore(n *node.Node) float64 {
+	if n.Stats.LoadStats == nil {
+		return 0
+	}
+	cores := n.Cores
+	if cores <= 0 {
+		cores = 1
+	}
+	return n.Stats.LoadStats.Last1Min / float64(cores)
+}
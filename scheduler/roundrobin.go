@@ -0,0 +1,50 @@
+package scheduler
+
+import (
+	"github.com/araminian/cube/node"
+	"github.com/araminian/cube/task"
+)
+
+// RoundRobin places tasks on each node in turn, ignoring their load.
+type RoundRobin struct {
+	Name       string
+	LastWorker int
+}
+
+func (r *RoundRobin) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	var candidates []*node.Node
+	for _, n := range nodes {
+		if portsFree(t, n) {
+			candidates = append(candidates, n)
+		}
+	}
+	return candidates
+}
+
+func (r *RoundRobin) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	nodeScores := make(map[string]float64)
+	if len(nodes) == 0 {
+		return nodeScores
+	}
+
+	var newWorker int
+	if r.LastWorker+1 < len(nodes) {
+		newWorker = r.LastWorker + 1
+	} else {
+		newWorker = 0
+	}
+	r.LastWorker = newWorker
+
+	for idx, n := range nodes {
+		if idx == newWorker {
+			nodeScores[n.Name] = 0.1
+		} else {
+			nodeScores[n.Name] = 1.0
+		}
+	}
+	return nodeScores
+}
+
+func (r *RoundRobin) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	return pickLowest(scores, candidates)
+}
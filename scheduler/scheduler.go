@@ -1,7 +1,70 @@
 package scheduler
 
+import (
+	"fmt"
+
+	"github.com/araminian/cube/node"
+	"github.com/araminian/cube/task"
+)
+
+// Scheduler decides which node a task is placed on. The manager first asks
+// for the candidate nodes able to run the task, scores them and then lets
+// the scheduler pick one of them.
 type Scheduler interface {
-	SelectCandidateNodes()
-	Score()
-	Pick()
+	SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node
+	Score(t task.Task, nodes []*node.Node) map[string]float64
+	Pick(scores map[string]float64, candidates []*node.Node) *node.Node
+}
+
+// New returns the scheduler registered under name.
+func New(name string) (Scheduler, error) {
+	switch name {
+	case "", "roundrobin":
+		return &RoundRobin{Name: "roundrobin"}, nil
+	case "leastloaded":
+		return &LeastLoaded{Name: "leastloaded"}, nil
+	case "epvm":
+		return &Epvm{Name: "epvm"}, nil
+	case "binpack":
+		return &BinPack{Name: "binpack"}, nil
+	default:
+		return nil, fmt.Errorf("unknown scheduler %q", name)
+	}
+}
+
+// portsFree reports whether the host ports t binds are all free on n. Two
+// tasks binding the same host port can't run on the same node, whatever
+// the scheduler.
+func portsFree(t task.Task, n *node.Node) bool {
+	return n.HostPortsFree(t.ReservedHostPorts())
+}
+
+// fits reports whether n has enough memory and disk left for t beyond what
+// is allocated to the tasks already placed on it.
+func fits(t task.Task, n *node.Node) bool {
+	if t.Memory > 0 && t.Memory > n.Memory-n.MemoryAllocated {
+		return false
+	}
+	if t.Disk > 0 && t.Disk > n.Disk-n.DiskAllocated {
+		return false
+	}
+	return true
+}
+
+// pickLowest returns the candidate with the lowest score, preferring the
+// earliest candidate on ties.
+func pickLowest(scores map[string]float64, candidates []*node.Node) *node.Node {
+	var best *node.Node
+	var lowest float64
+	for _, n := range candidates {
+		score, ok := scores[n.Name]
+		if !ok {
+			continue
+		}
+		if best == nil || score < lowest {
+			best = n
+			lowest = score
+		}
+	}
+	return best
 }
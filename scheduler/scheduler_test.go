@@ -0,0 +1,223 @@
+package scheduler
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/araminian/cube/node"
+	"github.com/araminian/cube/stats"
+	"github.com/araminian/cube/task"
+	"github.com/c9s/goprocinfo/linux"
+)
+
+const gb = 1 << 30
+
+// schedule places t on one of nodes the way the manager does and returns
+// the name of the node, or "" when t is placed nowhere.
+func schedule(s Scheduler, t task.Task, nodes ...*node.Node) string {
+	candidates := s.SelectCandidateNodes(t, nodes)
+	if len(candidates) == 0 {
+		return ""
+	}
+	n := s.Pick(s.Score(t, candidates), candidates)
+	if n == nil {
+		return ""
+	}
+	return n.Name
+}
+
+func names(nodes []*node.Node) []string {
+	var names []string
+	for _, n := range nodes {
+		names = append(names, n.Name)
+	}
+	return names
+}
+
+func withPort(t task.Task, port string) task.Task {
+	t.PortBindings = map[string]string{"80/tcp": port}
+	return t
+}
+
+func withLoad(n *node.Node, load float64) *node.Node {
+	n.Stats.LoadStats = &linux.LoadAvg{Last1Min: load}
+	return n
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range []string{"", "roundrobin", "leastloaded", "epvm", "binpack"} {
+		if _, err := New(name); err != nil {
+			t.Errorf("scheduler %q: %v", name, err)
+		}
+	}
+	if _, err := New("random"); err == nil {
+		t.Error("unknown scheduler was created")
+	}
+}
+
+func TestPickLowest(t *testing.T) {
+	a, b, c := &node.Node{Name: "a"}, &node.Node{Name: "b"}, &node.Node{Name: "c"}
+	tests := []struct {
+		scores     map[string]float64
+		candidates []*node.Node
+		want       string
+	}{
+		{map[string]float64{"a": 3, "b": 1, "c": 2}, []*node.Node{a, b, c}, "b"},
+		{map[string]float64{"a": 1, "b": 1, "c": 1}, []*node.Node{c, b, a}, "c"},
+		{map[string]float64{"a": -1, "b": 0}, []*node.Node{a, b}, "a"},
+		// Nodes without a score are not picked.
+		{map[string]float64{"b": 5}, []*node.Node{a, b, c}, "b"},
+		{map[string]float64{}, []*node.Node{a, b}, ""},
+		{map[string]float64{"a": 1}, nil, ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if n := pickLowest(tt.scores, tt.candidates); n != nil {
+			got = n.Name
+		}
+		if got != tt.want {
+			t.Errorf("lowest of %v among %v is %q, want %q", tt.scores, names(tt.candidates), got, tt.want)
+		}
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	r := &RoundRobin{}
+	a, b, c := &node.Node{Name: "a"}, &node.Node{Name: "b"}, &node.Node{Name: "c"}
+
+	var got []string
+	for range 4 {
+		got = append(got, schedule(r, task.Task{}, a, b, c))
+	}
+	if want := []string{"b", "c", "a", "b"}; !slices.Equal(got, want) {
+		t.Errorf("tasks went to %v, want %v", got, want)
+	}
+
+	b.HostPortsAllocated = []string{"8080/tcp"}
+	candidates := r.SelectCandidateNodes(withPort(task.Task{}, "8080"), []*node.Node{a, b, c})
+	if got := names(candidates); !slices.Equal(got, []string{"a", "c"}) {
+		t.Errorf("candidates for a task binding a port taken on b are %v", got)
+	}
+	if got := schedule(r, task.Task{}); got != "" {
+		t.Errorf("task went to %q without nodes", got)
+	}
+}
+
+func TestLeastLoaded(t *testing.T) {
+	l := &LeastLoaded{}
+	tests := []struct {
+		name  string
+		task  task.Task
+		nodes []*node.Node
+		want  string
+	}{
+		{
+			"fewest tasks",
+			task.Task{},
+			[]*node.Node{{Name: "a", TaskCounts: 3}, {Name: "b", TaskCounts: 1}, {Name: "c", TaskCounts: 2}},
+			"b",
+		},
+		{
+			"task count before load",
+			task.Task{},
+			[]*node.Node{withLoad(&node.Node{Name: "a", TaskCounts: 2, Cores: 1}, 0), withLoad(&node.Node{Name: "b", TaskCounts: 1, Cores: 1}, 8)},
+			"b",
+		},
+		{
+			"load per core breaks ties",
+			task.Task{},
+			[]*node.Node{withLoad(&node.Node{Name: "a", TaskCounts: 1, Cores: 2}, 1.5), withLoad(&node.Node{Name: "b", TaskCounts: 1, Cores: 4}, 2)},
+			"b",
+		},
+		{
+			"port taken",
+			withPort(task.Task{}, "8080"),
+			[]*node.Node{{Name: "a", HostPortsAllocated: []string{"8080/tcp"}}, {Name: "b", TaskCounts: 5}},
+			"b",
+		},
+		{
+			"port taken everywhere",
+			withPort(task.Task{}, "8080"),
+			[]*node.Node{{Name: "a", HostPortsAllocated: []string{"8080/tcp"}}},
+			"",
+		},
+	}
+	for _, tt := range tests {
+		if got := schedule(l, tt.task, tt.nodes...); got != tt.want {
+			t.Errorf("%s: task went to %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEpvm(t *testing.T) {
+	e := &Epvm{}
+	tests := []struct {
+		name  string
+		task  task.Task
+		nodes []*node.Node
+		want  string
+	}{
+		{
+			"least memory used",
+			task.Task{Memory: gb},
+			[]*node.Node{
+				{Name: "a", Cores: 4, Memory: 4 * gb, MemoryAllocated: 2 * gb},
+				{Name: "b", Cores: 4, Memory: 4 * gb, MemoryAllocated: gb},
+			},
+			"b",
+		},
+		{
+			"memory in use counts",
+			task.Task{Memory: gb},
+			[]*node.Node{
+				{Name: "a", Cores: 4, Memory: 4 * gb, Stats: stats.Stats{MemStats: &linux.MemInfo{MemTotal: 4 * gb / 1024, MemAvailable: gb / 1024}}},
+				{Name: "b", Cores: 4, Memory: 4 * gb, MemoryAllocated: gb},
+			},
+			"b",
+		},
+		{
+			"more cores",
+			task.Task{},
+			[]*node.Node{{Name: "a", Cores: 2}, {Name: "b", Cores: 8}},
+			"b",
+		},
+		{
+			"lower load",
+			task.Task{},
+			[]*node.Node{withLoad(&node.Node{Name: "a", Cores: 4}, 3), withLoad(&node.Node{Name: "b", Cores: 4}, 1)},
+			"b",
+		},
+		{
+			"not enough memory",
+			task.Task{Memory: 2 * gb},
+			[]*node.Node{
+				{Name: "a", Cores: 64, Memory: 4 * gb, MemoryAllocated: 3 * gb},
+				{Name: "b", Cores: 1, Memory: 8 * gb, MemoryAllocated: 5 * gb},
+			},
+			"b",
+		},
+		{
+			"not enough disk",
+			task.Task{Disk: 10 * gb},
+			[]*node.Node{{Name: "a", Cores: 64, Disk: 20 * gb, DiskAllocated: 15 * gb}, {Name: "b", Cores: 1, Disk: 20 * gb}},
+			"b",
+		},
+		{
+			"port taken",
+			withPort(task.Task{}, "8080"),
+			[]*node.Node{{Name: "a", Cores: 64, HostPortsAllocated: []string{"8080/tcp"}}, {Name: "b", Cores: 1}},
+			"b",
+		},
+		{
+			"fits nowhere",
+			task.Task{Memory: 8 * gb},
+			[]*node.Node{{Name: "a", Cores: 4, Memory: 4 * gb}, {Name: "b", Cores: 4, Memory: 8 * gb, MemoryAllocated: gb}},
+			"",
+		},
+	}
+	for _, tt := range tests {
+		if got := schedule(e, tt.task, tt.nodes...); got != tt.want {
+			t.Errorf("%s: task went to %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/araminian/cube/task"
+	"github.com/google/uuid"
+)
+
+// RevisionHistoryLimit is how many revisions of a service are kept to roll
+// back to.
+const RevisionHistoryLimit = 10
+
+// Service keeps Replicas tasks created from Task running. The manager
+// starts new tasks when there are fewer and stops tasks when there are
+// more. Changing Task starts a rolling update that replaces the tasks of
+// the previous revision with tasks of the new one.
+type Service struct {
+	ID           uuid.UUID
+	Name         string
+	Task         task.Task
+	Replicas     int
+	UpdateConfig UpdateConfig
+	CreateTime   time.Time
+
+	// Revision is the revision Task belongs to. Revisions holds the last
+	// revisions of the service, oldest first, including the current one.
+	Revision     int
+	Revisions    []Revision
+	UpdateStatus UpdateStatus
+
+	// RunningReplicas and Tasks report the live tasks of the service. They
+	// are filled in when the service is read and not stored.
+	RunningReplicas int
+	Tasks           []task.Task
+}
+
+type Revision struct {
+	Number     int
+	Task       task.Task
+	CreateTime time.Time
+}
+
+type FailureAction string
+
+const (
+	// Rollback rolls a failed update back to the previous revision.
+	Rollback FailureAction = "rollback"
+	// Pause leaves a failed update as it is until the service is updated
+	// or rolled back by hand.
+	Pause FailureAction = "pause"
+)
+
+// UpdateConfig controls how the tasks of a service are replaced during an
+// update. MaxSurge is how many tasks may run on top of Replicas and
+// MaxUnavailable how many of Replicas may be unavailable while tasks are
+// replaced. When both are zero, one task is added at a time.
+type UpdateConfig struct {
+	MaxUnavailable int
+	MaxSurge       int
+	// FailureAction is what happens when a task of the new revision
+	// fails. Empty rolls back.
+	FailureAction FailureAction
+}
+
+// Limits returns the surge and unavailability to use for an update.
+func (c UpdateConfig) Limits() (surge, unavailable int) {
+	if c.MaxSurge == 0 && c.MaxUnavailable == 0 {
+		return 1, 0
+	}
+	return c.MaxSurge, c.MaxUnavailable
+}
+
+type UpdateState string
+
+const (
+	Updating    UpdateState = "Updating"
+	RollingBack UpdateState = "RollingBack"
+	// Paused updates stopped replacing tasks after a task of the new
+	// revision failed.
+	Paused     UpdateState = "Paused"
+	Completed  UpdateState = "Completed"
+	RolledBack UpdateState = "RolledBack"
+)
+
+// UpdateStatus reports the progress of the last update of a service.
+type UpdateStatus struct {
+	State UpdateState
+	// PreviousRevision is the revision the update started from, which a
+	// failed update rolls back to.
+	PreviousRevision int
+	Message          string
+	StartTime        time.Time
+	FinishTime       time.Time
+}
+
+// Active reports whether the update still replaces tasks.
+func (u UpdateStatus) Active() bool {
+	return u.State == Updating || u.State == RollingBack
+}
+
+// Validate checks that the service can be reconciled.
+func (s *Service) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if err := ValidateTask(s.Task); err != nil {
+		return err
+	}
+	if s.Replicas < 0 {
+		return fmt.Errorf("replicas must not be negative")
+	}
+	return s.UpdateConfig.Validate()
+}
+
+// ValidateTask checks that t can be the task of a service.
+func ValidateTask(t task.Task) error {
+	if t.Image == "" {
+		return fmt.Errorf("task image is required")
+	}
+	if t.Mode != task.ModeService {
+		return fmt.Errorf("service tasks must run in service mode")
+	}
+	return t.Validate()
+}
+
+func (c UpdateConfig) Validate() error {
+	if c.MaxSurge < 0 || c.MaxUnavailable < 0 {
+		return fmt.Errorf("max surge and max unavailable must not be negative")
+	}
+	if c.FailureAction != "" && c.FailureAction != Rollback && c.FailureAction != Pause {
+		return fmt.Errorf("unknown failure action %q", c.FailureAction)
+	}
+	return nil
+}
+
+// AddRevision makes t the task of a new revision of the service and drops
+// the oldest revisions beyond RevisionHistoryLimit. It returns the number
+// of the new revision.
+func (s *Service) AddRevision(t task.Task) int {
+	number := 1
+	if n := len(s.Revisions); n > 0 {
+		number = s.Revisions[n-1].Number + 1
+	}
+	s.Revisions = append(s.Revisions, Revision{Number: number, Task: t, CreateTime: time.Now()})
+	if n := len(s.Revisions) - RevisionHistoryLimit; n > 0 {
+		s.Revisions = s.Revisions[n:]
+	}
+	s.Task = t
+	s.Revision = number
+	return number
+}
+
+// GetRevision returns the revision with the given number if it is still
+// kept.
+func (s *Service) GetRevision(number int) (Revision, bool) {
+	for _, r := range s.Revisions {
+		if r.Number == number {
+			return r, true
+		}
+	}
+	return Revision{}, false
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/araminian/cube/task"
+)
+
+func TestAddRevision(t *testing.T) {
+	s := Service{}
+	for i := 1; i <= RevisionHistoryLimit+5; i++ {
+		image := fmt.Sprintf("v%d", i)
+		if n := s.AddRevision(task.Task{Image: image}); n != i {
+			t.Fatalf("revision of %s is %d, want %d", image, n, i)
+		}
+		if s.Revision != i || s.Task.Image != image {
+			t.Fatalf("service is at revision %d running %s, want %d running %s", s.Revision, s.Task.Image, i, image)
+		}
+	}
+
+	if len(s.Revisions) != RevisionHistoryLimit {
+		t.Fatalf("%d revisions are kept, want %d", len(s.Revisions), RevisionHistoryLimit)
+	}
+	if _, ok := s.GetRevision(5); ok {
+		t.Error("revision 5 is kept beyond the history limit")
+	}
+	for n := 6; n <= RevisionHistoryLimit+5; n++ {
+		r, ok := s.GetRevision(n)
+		if !ok || r.Task.Image != fmt.Sprintf("v%d", n) {
+			t.Errorf("revision %d is %+v, %v", n, r, ok)
+		}
+	}
+}
+
+func TestUpdateConfigLimits(t *testing.T) {
+	tests := []struct {
+		cfg                UpdateConfig
+		surge, unavailable int
+	}{
+		{UpdateConfig{}, 1, 0},
+		{UpdateConfig{MaxSurge: 2}, 2, 0},
+		{UpdateConfig{MaxUnavailable: 1}, 0, 1},
+		{UpdateConfig{MaxSurge: 3, MaxUnavailable: 2}, 3, 2},
+	}
+	for _, tt := range tests {
+		surge, unavailable := tt.cfg.Limits()
+		if surge != tt.surge || unavailable != tt.unavailable {
+			t.Errorf("limits of %+v are %d and %d, want %d and %d", tt.cfg, surge, unavailable, tt.surge, tt.unavailable)
+		}
+	}
+}
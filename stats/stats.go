@@ -1,7 +1,8 @@
-package worker
+package stats
 
 import (
 	"log"
+	"runtime"
 
 	"github.com/c9s/goprocinfo/linux"
 )
@@ -11,15 +12,17 @@ type Stats struct {
 	DiskStats *linux.Disk
 	CpuStats  *linux.CPUStat
 	LoadStats *linux.LoadAvg
+	CpuCount  int
 	TaskCount int
 }
 
-func getStats() Stats {
+func GetStats() Stats {
 	return Stats{
 		MemStats:  GetMemoryInfo(),
 		DiskStats: GetDiskInfo(),
 		CpuStats:  GetCpuStat(),
 		LoadStats: GetLoadAvg(),
+		CpuCount:  runtime.NumCPU(),
 		TaskCount: 0,
 	}
 }
@@ -0,0 +1,205 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// JournalStore keeps values in memory and appends every change to a JSON
+// lines file, which is replayed when the store is opened again. The journal
+// is compacted on open and whenever it holds many more entries than there
+// are keys.
+type JournalStore[T any] struct {
+	mu      sync.RWMutex
+	path    string
+	file    *os.File
+	db      map[string]T
+	entries int
+}
+
+type journalEntry[T any] struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value *T     `json:"value,omitempty"`
+}
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+func NewJournalStore[T any](path string) (*JournalStore[T], error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &JournalStore[T]{
+		path: path,
+		db:   make(map[string]T),
+	}
+	err = s.replay()
+	if err != nil {
+		return nil, err
+	}
+	err = s.compact()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *JournalStore[T]) replay() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var e journalEntry[T]
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			// A torn last write must not keep the store from opening.
+			log.Printf("Skipping corrupt entry at %s:%d: %v", s.path, line, err)
+			continue
+		}
+		s.apply(e)
+	}
+	return scanner.Err()
+}
+
+// apply makes the change e records to s.db.
+func (s *JournalStore[T]) apply(e journalEntry[T]) {
+	switch e.Op {
+	case opPut:
+		if e.Value != nil {
+			s.db[e.Key] = *e.Value
+		}
+	case opDelete:
+		delete(s.db, e.Key)
+	}
+}
+
+// compact rewrites the journal with a single put per key. s.mu must be held
+// or the store not yet shared.
+func (s *JournalStore[T]) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for k, v := range s.db {
+		v := v
+		err := enc.Encode(journalEntry[T]{Op: opPut, Key: k, Value: &v})
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, s.path)
+	if err != nil {
+		return err
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.entries = len(s.db)
+	return nil
+}
+
+// append writes e to the journal and applies it. Only then is the journal
+// compacted, as compaction rewrites it from s.db. s.mu must be held.
+func (s *JournalStore[T]) append(e journalEntry[T]) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("writing journal %s: %w", s.path, err)
+	}
+	s.apply(e)
+
+	s.entries++
+	if s.entries > 2*len(s.db)+1000 {
+		return s.compact()
+	}
+	return nil
+}
+
+func (s *JournalStore[T]) Put(key string, value T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.append(journalEntry[T]{Op: opPut, Key: key, Value: &value})
+}
+
+func (s *JournalStore[T]) Get(key string) (T, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.db[key]
+	if !ok {
+		return value, ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *JournalStore[T]) List() ([]T, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	values := make([]T, 0, len(s.db))
+	for _, v := range s.db {
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (s *JournalStore[T]) Count() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.db), nil
+}
+
+func (s *JournalStore[T]) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.db[key]; !ok {
+		return nil
+	}
+	return s.append(journalEntry[T]{Op: opDelete, Key: key})
+}
+
+func (s *JournalStore[T]) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
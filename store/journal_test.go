@@ -0,0 +1,152 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+type item struct {
+	Name  string
+	Count int
+}
+
+func openJournal(t *testing.T, path string) *JournalStore[item] {
+	t.Helper()
+	s, err := NewJournalStore[item](path)
+	if err != nil {
+		t.Fatalf("opening journal: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func keys(t *testing.T, s *JournalStore[item]) []string {
+	t.Helper()
+	items, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, it := range items {
+		names = append(names, it.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestJournalReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db", "items.jsonl")
+	s := openJournal(t, path)
+	for i, name := range []string{"a", "b", "c"} {
+		if err := s.Put(name, item{Name: name, Count: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Put("b", item{Name: "b", Count: 10})
+	s.Delete("c")
+	s.Delete("missing")
+	s.Close()
+
+	s = openJournal(t, path)
+	if got := fmt.Sprint(keys(t, s)); got != "[a b]" {
+		t.Errorf("reopened store holds %s, want [a b]", got)
+	}
+	if b, err := s.Get("b"); err != nil || b.Count != 10 {
+		t.Errorf("b is %+v, %v, want the last value put", b, err)
+	}
+	if _, err := s.Get("c"); err != ErrNotFound {
+		t.Errorf("getting deleted key: %v, want ErrNotFound", err)
+	}
+	// Opening compacts the journal to one put per key.
+	if n := lines(t, path); n != 2 {
+		t.Errorf("journal has %d lines after opening, want 2", n)
+	}
+}
+
+func TestJournalTruncatedLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.jsonl")
+	s := openJournal(t, path)
+	s.Put("a", item{Name: "a"})
+	s.Put("b", item{Name: "b"})
+	s.Close()
+
+	// A write torn by a crash leaves half a line behind.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"put","key":"c","value":{"Na`)
+	f.Close()
+
+	s = openJournal(t, path)
+	if got := fmt.Sprint(keys(t, s)); got != "[a b]" {
+		t.Errorf("store holds %s, want [a b]", got)
+	}
+	s.Put("d", item{Name: "d"})
+	s.Close()
+
+	s = openJournal(t, path)
+	if got := fmt.Sprint(keys(t, s)); got != "[a b d]" {
+		t.Errorf("store holds %s after writing past the torn line, want [a b d]", got)
+	}
+}
+
+func TestJournalCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.jsonl")
+	s := openJournal(t, path)
+	for i := range 3000 {
+		name := fmt.Sprint(i % 10)
+		if err := s.Put(name, item{Name: name, Count: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := lines(t, path); n > 1100 {
+		t.Errorf("journal has %d lines, it was not compacted", n)
+	}
+	s.Close()
+
+	s = openJournal(t, path)
+	for i := range 10 {
+		it, err := s.Get(fmt.Sprint(i))
+		if err != nil || it.Count != 2990+i {
+			t.Errorf("key %d is %+v, %v, want count %d", i, it, err, 2990+i)
+		}
+	}
+}
+
+func TestJournalCompactionKeepsTriggeringWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.jsonl")
+	s := openJournal(t, path)
+	s.Put("a", item{Name: "a", Count: 1})
+	s.Put("b", item{Name: "b"})
+
+	// The next write compacts the journal.
+	s.entries = 2*len(s.db) + 1000
+	s.Put("a", item{Name: "a", Count: 2})
+	s.entries = 2*len(s.db) + 1000
+	s.Delete("b")
+	s.entries = 2*len(s.db) + 1000
+	s.Put("c", item{Name: "c"})
+	s.Close()
+
+	s = openJournal(t, path)
+	if got := fmt.Sprint(keys(t, s)); got != "[a c]" {
+		t.Errorf("store holds %s, want [a c]", got)
+	}
+	if a, _ := s.Get("a"); a.Count != 2 {
+		t.Errorf("a has count %d, want 2", a.Count)
+	}
+}
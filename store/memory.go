@@ -0,0 +1,56 @@
+package store
+
+import "sync"
+
+// MemoryStore keeps values in memory only; they are lost when the process
+// exits.
+type MemoryStore[T any] struct {
+	mu sync.RWMutex
+	db map[string]T
+}
+
+func NewMemoryStore[T any]() *MemoryStore[T] {
+	return &MemoryStore[T]{
+		db: make(map[string]T),
+	}
+}
+
+func (s *MemoryStore[T]) Put(key string, value T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.db[key] = value
+	return nil
+}
+
+func (s *MemoryStore[T]) Get(key string) (T, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.db[key]
+	if !ok {
+		return value, ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *MemoryStore[T]) List() ([]T, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	values := make([]T, 0, len(s.db))
+	for _, v := range s.db {
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (s *MemoryStore[T]) Count() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.db), nil
+}
+
+func (s *MemoryStore[T]) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.db, key)
+	return nil
+}
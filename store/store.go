@@ -0,0 +1,16 @@
+package store
+
+import "errors"
+
+var ErrNotFound = errors.New("key not found")
+
+// Store is a key/value store for the tasks and events kept by workers and
+// the manager. Values are stored by value, so callers must Put a value
+// again after changing it.
+type Store[T any] interface {
+	Put(key string, value T) error
+	Get(key string) (T, error)
+	List() ([]T, error)
+	Count() (int, error)
+	Delete(key string) error
+}
@@ -0,0 +1,396 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+)
+
+// Docker is the Runtime backed by a Docker daemon.
+type Docker struct {
+	Client *client.Client
+}
+
+func NewDocker() (*Docker, error) {
+	dc, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return nil, err
+	}
+	return &Docker{
+		Client: dc,
+	}, nil
+}
+
+func (d *Docker) Pull(ctx context.Context, img string) error {
+	reader, err := d.Client.ImagePull(ctx, img, image.PullOptions{})
+	if err != nil {
+		log.Printf("Error pulling image %s: %v", img, err)
+		return err
+	}
+	defer reader.Close()
+	io.Copy(os.Stdout, reader)
+	return nil
+}
+
+func (d *Docker) Create(ctx context.Context, config Config) (string, error) {
+	rp := container.RestartPolicy{
+		Name: container.RestartPolicyMode(config.RestartPolicy),
+	}
+
+	r := container.Resources{
+		Memory:   int64(config.Memory),
+		NanoCPUs: int64(config.Cpu * math.Pow(10, 9)),
+	}
+
+	exposed, portBindings, err := ParsePortBindings(config.PortBindings)
+	if err != nil {
+		return "", err
+	}
+	for port := range config.ExposedPorts {
+		exposed[port] = struct{}{}
+		if _, ok := portBindings[port]; !ok {
+			portBindings[port] = []nat.PortBinding{{}}
+		}
+	}
+
+	cc := container.Config{
+		Image:        config.Image,
+		Tty:          false,
+		Env:          config.Env,
+		Cmd:          config.Cmd,
+		Labels:       config.Labels,
+		ExposedPorts: exposed,
+		WorkingDir:   config.WorkingDir,
+		User:         config.User,
+	}
+
+	var mounts []mount.Mount
+	for _, m := range config.Mounts {
+		mounts = append(mounts, mount.Mount{
+			Type:     mount.Type(m.Type),
+			Source:   m.Source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		})
+	}
+
+	// Exposed ports without a binding are published on free host ports.
+	// Tasks that bind no ports of their own keep publishing every port the
+	// image exposes, the others only publish the ports they ask for.
+	hc := container.HostConfig{
+		RestartPolicy:   rp,
+		Resources:       r,
+		PortBindings:    portBindings,
+		PublishAllPorts: len(config.PortBindings) == 0,
+		Mounts:          mounts,
+	}
+
+	resp, err := d.Client.ContainerCreate(
+		ctx,
+		&cc,
+		&hc,
+		nil,
+		nil,
+		config.Name,
+	)
+	if err != nil {
+		log.Printf("Error creating container %s from image %s: %v", config.Name, config.Image, err)
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+func (d *Docker) Start(ctx context.Context, id string) error {
+	err := d.Client.ContainerStart(ctx, id, container.StartOptions{})
+	if err != nil {
+		log.Printf("Error starting container %s: %v", id, err)
+	}
+	return err
+}
+
+func (d *Docker) Stop(ctx context.Context, id string) error {
+	log.Printf("Stopping container %s", id)
+	err := d.Client.ContainerStop(ctx, id, container.StopOptions{})
+	if err != nil {
+		log.Printf("Error stopping container %s: %v", id, err)
+	}
+	return err
+}
+
+func (d *Docker) Remove(ctx context.Context, id string) error {
+	err := d.Client.ContainerRemove(ctx, id, container.RemoveOptions{
+		RemoveVolumes: true,
+		RemoveLinks:   false,
+		Force:         false,
+	})
+	if err != nil {
+		log.Printf("Error removing container %s: %v", id, err)
+	}
+	return err
+}
+
+func (d *Docker) Inspect(ctx context.Context, id string) (ContainerInfo, error) {
+	resp, err := d.Client.ContainerInspect(ctx, id)
+	if err != nil {
+		log.Printf("Error inspecting container %s: %v", id, err)
+		return ContainerInfo{}, err
+	}
+
+	info := ContainerInfo{
+		ID:   resp.ID,
+		Name: strings.TrimPrefix(resp.Name, "/"),
+	}
+	if resp.Config != nil {
+		info.Image = resp.Config.Image
+		info.Labels = resp.Config.Labels
+	}
+	if resp.State != nil {
+		info.Status = resp.State.Status
+		info.Running = resp.State.Running
+		info.ExitCode = resp.State.ExitCode
+		info.OOMKilled = resp.State.OOMKilled
+		info.Error = resp.State.Error
+		info.StartedAt = parseDockerTime(resp.State.StartedAt)
+		info.FinishedAt = parseDockerTime(resp.State.FinishedAt)
+	}
+	if resp.NetworkSettings != nil {
+		info.HostPorts = make(map[string]string)
+		for port, bindings := range resp.NetworkSettings.Ports {
+			if len(bindings) > 0 {
+				info.HostPorts[string(port)] = bindings[0].HostPort
+			}
+		}
+	}
+	return info, nil
+}
+
+func (d *Docker) Exits(ctx context.Context) (<-chan string, <-chan error) {
+	msgs, errs := d.Client.Events(ctx, events.ListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("event", string(events.ActionDie)),
+		),
+	})
+
+	exits := make(chan string)
+	go func() {
+		defer close(exits)
+		for {
+			select {
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				select {
+				case exits <- msg.Actor.ID:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return exits, errs
+}
+
+func (d *Docker) List(ctx context.Context, labels map[string]string) ([]ContainerInfo, error) {
+	args := filters.NewArgs()
+	for k, v := range labels {
+		args.Add("label", fmt.Sprintf("%s=%s", k, v))
+	}
+
+	containers, err := d.Client.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: args,
+	})
+	if err != nil {
+		log.Printf("Error listing containers: %v", err)
+		return nil, err
+	}
+
+	var infos []ContainerInfo
+	for _, c := range containers {
+		info, err := d.Inspect(ctx, c.ID)
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (d *Docker) Exec(ctx context.Context, id string, cmd []string) (ExecResult, error) {
+	exec, err := d.Client.ContainerExecCreate(ctx, id, container.ExecOptions{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+	})
+	if err != nil {
+		log.Printf("Error creating exec in container %s: %v", id, err)
+		return ExecResult{}, err
+	}
+
+	resp, err := d.Client.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		log.Printf("Error attaching to exec %s in container %s: %v", exec.ID, id, err)
+		return ExecResult{}, err
+	}
+	defer resp.Close()
+
+	var out bytes.Buffer
+	_, err = stdcopy.StdCopy(&out, &out, resp.Reader)
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	inspect, err := d.Client.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return ExecResult{}, err
+	}
+	return ExecResult{
+		ExitCode: inspect.ExitCode,
+		Output:   out.String(),
+	}, nil
+}
+
+func (d *Docker) ExecAttach(ctx context.Context, id string, opts ExecOptions) (ExecSession, error) {
+	exec, err := d.Client.ContainerExecCreate(ctx, id, container.ExecOptions{
+		AttachStdin:  opts.Stdin,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          opts.Tty,
+		Cmd:          opts.Cmd,
+	})
+	if err != nil {
+		log.Printf("Error creating exec in container %s: %v", id, err)
+		return nil, err
+	}
+
+	resp, err := d.Client.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{Tty: opts.Tty})
+	if err != nil {
+		log.Printf("Error attaching to exec %s in container %s: %v", exec.ID, id, err)
+		return nil, err
+	}
+
+	s := &dockerExec{client: d.Client, id: exec.ID, resp: resp, out: resp.Reader}
+	if !opts.Tty {
+		// Without a terminal stdout and stderr come multiplexed.
+		pr, pw := io.Pipe()
+		go func() {
+			_, err := stdcopy.StdCopy(pw, pw, resp.Reader)
+			pw.CloseWithError(err)
+		}()
+		s.out = pr
+	}
+	return s, nil
+}
+
+type dockerExec struct {
+	client *client.Client
+	id     string
+	resp   types.HijackedResponse
+	out    io.Reader
+}
+
+func (e *dockerExec) Read(p []byte) (int, error) {
+	return e.out.Read(p)
+}
+
+func (e *dockerExec) Write(p []byte) (int, error) {
+	return e.resp.Conn.Write(p)
+}
+
+func (e *dockerExec) CloseWrite() error {
+	return e.resp.CloseWrite()
+}
+
+func (e *dockerExec) Resize(ctx context.Context, height, width uint) error {
+	return e.client.ContainerExecResize(ctx, e.id, container.ResizeOptions{
+		Height: height,
+		Width:  width,
+	})
+}
+
+func (e *dockerExec) Wait(ctx context.Context) (int, error) {
+	for {
+		inspect, err := e.client.ContainerExecInspect(ctx, e.id)
+		if err != nil {
+			return 0, err
+		}
+		if !inspect.Running {
+			return inspect.ExitCode, nil
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// Close detaches from the command. Docker can't stop an exec'd command,
+// but most exit once their input and output are gone.
+func (e *dockerExec) Close() error {
+	e.resp.Close()
+	return nil
+}
+
+// Logs returns the combined stdout and stderr of the container as plain text.
+func (d *Docker) Logs(ctx context.Context, id string, opts LogOptions) (io.ReadCloser, error) {
+	out, err := d.Client.ContainerLogs(ctx, id, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     opts.Follow,
+		Tail:       opts.Tail,
+		Since:      opts.Since,
+		Timestamps: opts.Timestamps,
+	})
+	if err != nil {
+		log.Printf("Error getting logs for container %s: %v", id, err)
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pw, pw, out)
+		pw.CloseWithError(err)
+	}()
+	return &logReader{PipeReader: pr, src: out}, nil
+}
+
+// logReader closes the underlying Docker stream along with the pipe so a
+// follow request stops as soon as the reader goes away.
+type logReader struct {
+	*io.PipeReader
+	src io.Closer
+}
+
+func (l *logReader) Close() error {
+	l.src.Close()
+	return l.PipeReader.Close()
+}
+
+func parseDockerTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil || t.Year() <= 1 {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,354 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// FakeBehavior describes how containers of a FakeRuntime behave.
+type FakeBehavior struct {
+	// StartDelay is how long Start blocks before the container is running.
+	StartDelay time.Duration
+	// CrashAfter, when non-zero, makes the container exit on its own this
+	// long after it started.
+	CrashAfter time.Duration
+	// ExitCode is the code a container exits with when it exits on its own.
+	ExitCode int
+	// PullError, when set, is returned by Pull.
+	PullError error
+	// ExecExitCode is the exit code of every command run with Exec.
+	ExecExitCode int
+}
+
+// FakeRuntime is an in-process Runtime that simulates containers without
+// talking to a container daemon. The zero value is not usable; create one
+// with NewFakeRuntime.
+type FakeRuntime struct {
+	// Default applies to every image without an entry in Images.
+	Default FakeBehavior
+	Images  map[string]FakeBehavior
+
+	mu         sync.Mutex
+	nextID     int
+	nextPort   int
+	containers map[string]*fakeContainer
+}
+
+type fakeContainer struct {
+	id         string
+	config     Config
+	behavior   FakeBehavior
+	status     string
+	exitCode   int
+	startedAt  time.Time
+	finishedAt time.Time
+	hostPorts  map[string]string
+	logs       bytes.Buffer
+}
+
+func NewFakeRuntime() *FakeRuntime {
+	return &FakeRuntime{
+		Images:     make(map[string]FakeBehavior),
+		nextPort:   32768,
+		containers: make(map[string]*fakeContainer),
+	}
+}
+
+func (f *FakeRuntime) behavior(image string) FakeBehavior {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if b, ok := f.Images[image]; ok {
+		return b
+	}
+	return f.Default
+}
+
+// SetBehavior changes how containers created from image behave.
+func (f *FakeRuntime) SetBehavior(image string, b FakeBehavior) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Images[image] = b
+}
+
+func (f *FakeRuntime) Pull(ctx context.Context, image string) error {
+	return f.behavior(image).PullError
+}
+
+func (f *FakeRuntime) Create(ctx context.Context, config Config) (string, error) {
+	b := f.behavior(config.Image)
+	_, bindings, err := ParsePortBindings(config.PortBindings)
+	if err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if other := f.named(config.Name); other != nil {
+		return "", fmt.Errorf("Conflict. The container name %q is already in use by container %q", "/"+config.Name, other.id)
+	}
+	f.nextID++
+	c := &fakeContainer{
+		id:        fmt.Sprintf("fake-%06d", f.nextID),
+		config:    config,
+		behavior:  b,
+		status:    "created",
+		hostPorts: make(map[string]string),
+	}
+	for port, pb := range bindings {
+		hostPort := pb[0].HostPort
+		if hostPort == "" {
+			hostPort = strconv.Itoa(f.nextPort)
+			f.nextPort++
+		}
+		c.hostPorts[string(port)] = hostPort
+	}
+	fmt.Fprintf(&c.logs, "created container %s from image %s\n", c.id, config.Image)
+	f.containers[c.id] = c
+	return c.id, nil
+}
+
+func (f *FakeRuntime) Start(ctx context.Context, id string) error {
+	f.mu.Lock()
+	c, ok := f.containers[id]
+	f.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no such container: %s", id)
+	}
+
+	if c.behavior.StartDelay > 0 {
+		select {
+		case <-time.After(c.behavior.StartDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c.status == "running" {
+		return nil
+	}
+	for port, hostPort := range c.hostPorts {
+		if other := f.portOwner(port, hostPort); other != nil {
+			return fmt.Errorf("host port %s is already allocated by container %s", hostPort, other.id)
+		}
+	}
+	c.status = "running"
+	c.startedAt = time.Now()
+	c.finishedAt = time.Time{}
+	c.exitCode = 0
+	fmt.Fprintf(&c.logs, "started container %s\n", c.id)
+	return nil
+}
+
+func (f *FakeRuntime) Stop(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.containers[id]
+	if !ok {
+		return fmt.Errorf("no such container: %s", id)
+	}
+	f.refresh(c)
+	if c.status == "running" {
+		c.status = "exited"
+		c.finishedAt = time.Now()
+		fmt.Fprintf(&c.logs, "stopped container %s\n", c.id)
+	}
+	return nil
+}
+
+func (f *FakeRuntime) Remove(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.containers[id]
+	if !ok {
+		return fmt.Errorf("no such container: %s", id)
+	}
+	f.refresh(c)
+	if c.status == "running" {
+		return fmt.Errorf("cannot remove running container %s", id)
+	}
+	delete(f.containers, id)
+	return nil
+}
+
+func (f *FakeRuntime) Inspect(ctx context.Context, id string) (ContainerInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.containers[id]
+	if !ok {
+		return ContainerInfo{}, fmt.Errorf("no such container: %s", id)
+	}
+	return f.info(c), nil
+}
+
+func (f *FakeRuntime) List(ctx context.Context, labels map[string]string) ([]ContainerInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var infos []ContainerInfo
+	for _, c := range f.containers {
+		info := f.info(c)
+		if info.HasLabels(labels) {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// info returns the current state of c. f.mu must be held.
+func (f *FakeRuntime) info(c *fakeContainer) ContainerInfo {
+	f.refresh(c)
+	return ContainerInfo{
+		ID:         c.id,
+		Name:       c.config.Name,
+		Image:      c.config.Image,
+		Labels:     c.config.Labels,
+		Status:     c.status,
+		Running:    c.status == "running",
+		ExitCode:   c.exitCode,
+		StartedAt:  c.startedAt,
+		FinishedAt: c.finishedAt,
+		HostPorts:  c.hostPorts,
+	}
+}
+
+// named returns the container called name, if any. Containers without a
+// name never conflict. f.mu must be held.
+func (f *FakeRuntime) named(name string) *fakeContainer {
+	if name == "" {
+		return nil
+	}
+	for _, c := range f.containers {
+		if c.config.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// portOwner returns the running container that publishes a port with the
+// protocol of port on hostPort, if any. f.mu must be held.
+func (f *FakeRuntime) portOwner(port, hostPort string) *fakeContainer {
+	proto, _ := nat.SplitProtoPort(port)
+	for _, c := range f.containers {
+		f.refresh(c)
+		if c.status != "running" {
+			continue
+		}
+		for p, h := range c.hostPorts {
+			if h == hostPort && nat.Port(p).Proto() == proto {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func (f *FakeRuntime) Logs(ctx context.Context, id string, opts LogOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.containers[id]
+	if !ok {
+		return nil, fmt.Errorf("no such container: %s", id)
+	}
+	f.refresh(c)
+	return io.NopCloser(bytes.NewReader(bytes.Clone(c.logs.Bytes()))), nil
+}
+
+func (f *FakeRuntime) Exec(ctx context.Context, id string, cmd []string) (ExecResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.containers[id]
+	if !ok {
+		return ExecResult{}, fmt.Errorf("no such container: %s", id)
+	}
+	f.refresh(c)
+	if c.status != "running" {
+		return ExecResult{}, fmt.Errorf("container %s is not running", id)
+	}
+	return ExecResult{ExitCode: c.behavior.ExecExitCode}, nil
+}
+
+// ExecAttach prints the command and, when it is given input, echoes it
+// back until the input is closed. The command then exits with the
+// ExecExitCode of the container.
+func (f *FakeRuntime) ExecAttach(ctx context.Context, id string, opts ExecOptions) (ExecSession, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.containers[id]
+	if !ok {
+		return nil, fmt.Errorf("no such container: %s", id)
+	}
+	f.refresh(c)
+	if c.status != "running" {
+		return nil, fmt.Errorf("container %s is not running", id)
+	}
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	e := &fakeExec{in: inW, out: outR, exitCode: c.behavior.ExecExitCode, done: make(chan struct{})}
+	go func() {
+		fmt.Fprintf(outW, "exec %s\n", strings.Join(opts.Cmd, " "))
+		if opts.Stdin {
+			io.Copy(outW, inR)
+		} else {
+			inR.Close()
+		}
+		outW.Close()
+		close(e.done)
+	}()
+	return e, nil
+}
+
+type fakeExec struct {
+	in       *io.PipeWriter
+	out      *io.PipeReader
+	exitCode int
+	done     chan struct{}
+}
+
+func (e *fakeExec) Read(p []byte) (int, error)  { return e.out.Read(p) }
+func (e *fakeExec) Write(p []byte) (int, error) { return e.in.Write(p) }
+func (e *fakeExec) CloseWrite() error           { return e.in.Close() }
+
+func (e *fakeExec) Resize(ctx context.Context, height, width uint) error {
+	return nil
+}
+
+func (e *fakeExec) Wait(ctx context.Context) (int, error) {
+	select {
+	case <-e.done:
+		return e.exitCode, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (e *fakeExec) Close() error {
+	e.in.Close()
+	return e.out.Close()
+}
+
+// refresh moves a running container to exited once its CrashAfter has
+// elapsed. f.mu must be held.
+func (f *FakeRuntime) refresh(c *fakeContainer) {
+	if c.status != "running" || c.behavior.CrashAfter <= 0 {
+		return
+	}
+	exitAt := c.startedAt.Add(c.behavior.CrashAfter)
+	if time.Now().Before(exitAt) {
+		return
+	}
+	c.status = "exited"
+	c.exitCode = c.behavior.ExitCode
+	c.finishedAt = exitAt
+	fmt.Fprintf(&c.logs, "container %s exited with code %d\n", c.id, c.exitCode)
+}
@@ -0,0 +1,45 @@
+package task
+
+import (
+	"fmt"
+	"path"
+)
+
+type MountType string
+
+const (
+	// MountBind mounts the host path Source into the container.
+	MountBind MountType = "bind"
+	// MountVolume mounts the named volume Source into the container,
+	// creating it if needed. Without a Source the volume is anonymous and
+	// removed along with the container.
+	MountVolume MountType = "volume"
+)
+
+// Mount makes a host path or a volume available in the container of a task
+// at Target.
+type Mount struct {
+	Type     MountType
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+func (m Mount) Validate() error {
+	if !path.IsAbs(m.Target) {
+		return fmt.Errorf("mount target %q must be an absolute path", m.Target)
+	}
+	switch m.Type {
+	case MountBind:
+		if !path.IsAbs(m.Source) {
+			return fmt.Errorf("bind mount source %q must be an absolute path", m.Source)
+		}
+	case MountVolume:
+		if path.IsAbs(m.Source) {
+			return fmt.Errorf("volume name %q must not be a path, use a bind mount", m.Source)
+		}
+	default:
+		return fmt.Errorf("unknown mount type %q", m.Type)
+	}
+	return nil
+}
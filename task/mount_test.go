@@ -0,0 +1,35 @@
+package task
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMountValidate(t *testing.T) {
+	tests := []struct {
+		mount Mount
+		err   string
+	}{
+		{Mount{Type: MountBind, Source: "/var/lib/app", Target: "/data"}, ""},
+		{Mount{Type: MountBind, Source: "/etc/app", Target: "/etc/app", ReadOnly: true}, ""},
+		{Mount{Type: MountVolume, Source: "data", Target: "/data"}, ""},
+		// Anonymous volume.
+		{Mount{Type: MountVolume, Target: "/cache"}, ""},
+		{Mount{Type: MountBind, Source: "/var/lib/app", Target: "data"}, `mount target "data" must be an absolute path`},
+		{Mount{Type: MountVolume, Source: "data"}, `mount target "" must be an absolute path`},
+		{Mount{Type: MountBind, Source: "app", Target: "/data"}, `bind mount source "app" must be an absolute path`},
+		{Mount{Type: MountBind, Target: "/data"}, `bind mount source "" must be an absolute path`},
+		{Mount{Type: MountVolume, Source: "/var/lib/app", Target: "/data"}, `volume name "/var/lib/app" must not be a path`},
+		{Mount{Source: "data", Target: "/data"}, `unknown mount type ""`},
+		{Mount{Type: "tmpfs", Target: "/tmp"}, `unknown mount type "tmpfs"`},
+	}
+	for _, tt := range tests {
+		err := tt.mount.Validate()
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%+v: unexpected error: %v", tt.mount, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%+v: got error %v, want one containing %q", tt.mount, err, tt.err)
+		}
+	}
+}
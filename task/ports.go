@@ -0,0 +1,64 @@
+package task
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// ParsePortBindings parses the PortBindings of a task, which map a
+// container port such as "80/tcp" or "80" to the host port it is published
+// on. An empty host port lets the runtime pick a free one. It returns the
+// container ports to expose and their bindings.
+func ParsePortBindings(bindings map[string]string) (nat.PortSet, nat.PortMap, error) {
+	exposed := make(nat.PortSet)
+	portMap := make(nat.PortMap)
+	for containerPort, hostPort := range bindings {
+		port, err := parsePort(containerPort)
+		if err != nil {
+			return nil, nil, err
+		}
+		if hostPort != "" {
+			p, err := nat.ParsePort(hostPort)
+			if err != nil || p == 0 {
+				return nil, nil, fmt.Errorf("invalid host port %q for port %s", hostPort, containerPort)
+			}
+		}
+		exposed[port] = struct{}{}
+		portMap[port] = append(portMap[port], nat.PortBinding{HostPort: hostPort})
+	}
+	return exposed, portMap, nil
+}
+
+// parsePort parses a single container port, defaulting to tcp.
+func parsePort(raw string) (nat.Port, error) {
+	proto, port := nat.SplitProtoPort(raw)
+	if proto != "tcp" && proto != "udp" && proto != "sctp" {
+		return "", fmt.Errorf("invalid protocol in port %q", raw)
+	}
+	p, err := nat.ParsePort(port)
+	if err != nil || p == 0 {
+		return "", fmt.Errorf("invalid port %q", raw)
+	}
+	return nat.NewPort(proto, port)
+}
+
+// ReservedHostPorts returns the host ports the task binds explicitly, e.g.
+// "8080/tcp". No two tasks on the same worker can bind the same one.
+func (t *Task) ReservedHostPorts() []string {
+	var ports []string
+	for containerPort, hostPort := range t.PortBindings {
+		port, err := parsePort(containerPort)
+		if err != nil {
+			continue
+		}
+		p, err := nat.ParsePort(hostPort)
+		if err != nil || p == 0 {
+			continue
+		}
+		ports = append(ports, fmt.Sprintf("%d/%s", p, port.Proto()))
+	}
+	sort.Strings(ports)
+	return ports
+}
@@ -0,0 +1,81 @@
+package task
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+)
+
+func TestParsePortBindings(t *testing.T) {
+	tests := []struct {
+		bindings map[string]string
+		want     nat.PortMap
+	}{
+		{nil, nat.PortMap{}},
+		{map[string]string{"80/tcp": "8080"}, nat.PortMap{"80/tcp": {{HostPort: "8080"}}}},
+		// The protocol defaults to tcp.
+		{map[string]string{"80": "8080"}, nat.PortMap{"80/tcp": {{HostPort: "8080"}}}},
+		{map[string]string{"53/udp": "53", "132/sctp": "1132"}, nat.PortMap{"53/udp": {{HostPort: "53"}}, "132/sctp": {{HostPort: "1132"}}}},
+		// An empty host port is picked by the runtime.
+		{map[string]string{"443": ""}, nat.PortMap{"443/tcp": {{HostPort: ""}}}},
+		{map[string]string{"65535/tcp": "65535", "1": "1"}, nat.PortMap{"65535/tcp": {{HostPort: "65535"}}, "1/tcp": {{HostPort: "1"}}}},
+	}
+	for _, tt := range tests {
+		exposed, bindings, err := ParsePortBindings(tt.bindings)
+		if err != nil {
+			t.Errorf("parsing %v: %v", tt.bindings, err)
+			continue
+		}
+		if len(bindings) != len(tt.want) || len(exposed) != len(tt.want) {
+			t.Errorf("%v exposes %v bound to %v, want %v", tt.bindings, exposed, bindings, tt.want)
+			continue
+		}
+		for port, want := range tt.want {
+			if _, ok := exposed[port]; !ok {
+				t.Errorf("%v does not expose %s", tt.bindings, port)
+			}
+			if got := bindings[port]; !slices.Equal(got, want) {
+				t.Errorf("%v binds %s to %v, want %v", tt.bindings, port, got, want)
+			}
+		}
+	}
+}
+
+func TestParsePortBindingsErrors(t *testing.T) {
+	tests := []struct {
+		containerPort, hostPort, err string
+	}{
+		{"", "8080", `port ""`},
+		{"http", "8080", `invalid port "http"`},
+		{"0", "8080", `invalid port "0"`},
+		{"65536", "8080", `invalid port "65536"`},
+		{"-80", "8080", `invalid port "-80"`},
+		{"80/icmp", "8080", `invalid protocol in port "80/icmp"`},
+		{"80/tcp", "http", `invalid host port "http" for port 80/tcp`},
+		{"80/tcp", "0", `invalid host port "0"`},
+		{"80/tcp", "70000", `invalid host port "70000"`},
+		{"80/tcp", "-1", `invalid host port "-1"`},
+		{"80/tcp", "127.0.0.1:8080", `invalid host port "127.0.0.1:8080"`},
+	}
+	for _, tt := range tests {
+		_, _, err := ParsePortBindings(map[string]string{tt.containerPort: tt.hostPort})
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("binding %q to %q: got error %v, want one containing %q", tt.containerPort, tt.hostPort, err, tt.err)
+		}
+	}
+}
+
+func TestReservedHostPorts(t *testing.T) {
+	tk := Task{PortBindings: map[string]string{
+		"80":       "8080",
+		"53/udp":   "53",
+		"443/tcp":  "",
+		"9000/tcp": "invalid",
+	}}
+	want := []string{"53/udp", "8080/tcp"}
+	if got := tk.ReservedHostPorts(); !slices.Equal(got, want) {
+		t.Errorf("reserved host ports are %v, want %v", got, want)
+	}
+}
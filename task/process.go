@@ -0,0 +1,529 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProcessRuntime runs a task's Cmd directly on the host as a child process
+// instead of in a container. The task image is ignored. Once started, a
+// process is identified by its PID.
+type ProcessRuntime struct {
+	// LogDir holds one log file per task with its combined stdout and stderr.
+	LogDir string
+	// CgroupRoot is the cgroup v2 directory under which each process gets
+	// its own cgroup to enforce Config.Memory. When cgroups are unavailable
+	// an address space rlimit, set before the command runs, is used instead.
+	CgroupRoot string
+	// StopTimeout is how long Stop waits after SIGTERM before sending SIGKILL.
+	StopTimeout time.Duration
+
+	mu    sync.Mutex
+	procs map[string]*process
+}
+
+type process struct {
+	handle     string
+	config     Config
+	cmd        *exec.Cmd
+	logPath    string
+	cgroup     string
+	started    bool
+	startedAt  time.Time
+	finishedAt time.Time
+	exitCode   int
+	err        error
+	done       chan struct{}
+}
+
+func NewProcessRuntime(logDir string) (*ProcessRuntime, error) {
+	err := os.MkdirAll(logDir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &ProcessRuntime{
+		LogDir:      logDir,
+		CgroupRoot:  "/sys/fs/cgroup/cube",
+		StopTimeout: 10 * time.Second,
+		procs:       make(map[string]*process),
+	}, nil
+}
+
+func (p *ProcessRuntime) lookup(id string) (*process, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	proc, ok := p.procs[id]
+	if !ok {
+		return nil, fmt.Errorf("no such process: %s", id)
+	}
+	return proc, nil
+}
+
+// Pull is a no-op since processes run host binaries.
+func (p *ProcessRuntime) Pull(ctx context.Context, image string) error {
+	return nil
+}
+
+func (p *ProcessRuntime) Create(ctx context.Context, config Config) (string, error) {
+	if len(config.Cmd) == 0 {
+		return "", errors.New("process runtime requires a command")
+	}
+	if len(config.Mounts) > 0 {
+		return "", errors.New("process runtime does not support mounts")
+	}
+	if config.User != "" {
+		return "", errors.New("process runtime does not support running as another user")
+	}
+
+	handle := fmt.Sprintf("%s-%d", sanitizeName(config.Name), time.Now().UnixNano())
+	proc := &process{
+		handle:  handle,
+		config:  config,
+		logPath: filepath.Join(p.LogDir, handle+".log"),
+		done:    make(chan struct{}),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.procs[handle] = proc
+	return handle, nil
+}
+
+func (p *ProcessRuntime) Start(ctx context.Context, id string) error {
+	proc, err := p.lookup(id)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	started := proc.started
+	p.mu.Unlock()
+	if started {
+		return nil
+	}
+
+	logFile, err := os.OpenFile(proc.logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(proc.config.Cmd[0], proc.config.Cmd[1:]...)
+	cmd.Env = append(os.Environ(), proc.config.Env...)
+	cmd.Dir = proc.config.WorkingDir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	setProcAttr(cmd)
+
+	if proc.config.Memory > 0 {
+		proc.cgroup, err = p.setupCgroup(cmd, proc)
+		if err != nil {
+			log.Printf("Cgroup unavailable for %s, falling back to rlimit: %v", proc.handle, err)
+			err := limitMemory(cmd, proc.config.Memory)
+			if err != nil {
+				log.Printf("Error limiting memory of process %s: %v", proc.handle, err)
+			}
+		}
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		logFile.Close()
+		log.Printf("Error starting process %s: %v", proc.handle, err)
+		return err
+	}
+	closeCgroupFD(cmd)
+
+	pid := strconv.Itoa(cmd.Process.Pid)
+
+	p.mu.Lock()
+	proc.cmd = cmd
+	proc.started = true
+	proc.startedAt = time.Now()
+	p.procs[pid] = proc
+	p.mu.Unlock()
+
+	go func() {
+		err := cmd.Wait()
+		logFile.Close()
+
+		p.mu.Lock()
+		proc.finishedAt = time.Now()
+		proc.exitCode = cmd.ProcessState.ExitCode()
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			proc.err = err
+		}
+		p.mu.Unlock()
+		close(proc.done)
+	}()
+
+	return nil
+}
+
+// Stop sends SIGTERM to the process group and escalates to SIGKILL when the
+// process has not exited after StopTimeout.
+func (p *ProcessRuntime) Stop(ctx context.Context, id string) error {
+	proc, err := p.lookup(id)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	started := proc.started
+	p.mu.Unlock()
+	if !started {
+		return nil
+	}
+
+	log.Printf("Stopping process %d", proc.cmd.Process.Pid)
+	select {
+	case <-proc.done:
+		return nil
+	default:
+	}
+
+	err = terminate(proc.cmd.Process)
+	if err != nil {
+		log.Printf("Error sending SIGTERM to process %d: %v", proc.cmd.Process.Pid, err)
+	}
+
+	select {
+	case <-proc.done:
+		return nil
+	case <-time.After(p.StopTimeout):
+	case <-ctx.Done():
+	}
+
+	log.Printf("Process %d did not exit after %s, killing it", proc.cmd.Process.Pid, p.StopTimeout)
+	err = kill(proc.cmd.Process)
+	if err != nil {
+		log.Printf("Error killing process %d: %v", proc.cmd.Process.Pid, err)
+		return err
+	}
+	<-proc.done
+	return nil
+}
+
+func (p *ProcessRuntime) Remove(ctx context.Context, id string) error {
+	proc, err := p.lookup(id)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	started := proc.started
+	p.mu.Unlock()
+	if started {
+		select {
+		case <-proc.done:
+		default:
+			return fmt.Errorf("cannot remove running process %s", id)
+		}
+	}
+
+	if proc.cgroup != "" {
+		err := os.Remove(proc.cgroup)
+		if err != nil {
+			log.Printf("Error removing cgroup %s: %v", proc.cgroup, err)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for k, v := range p.procs {
+		if v == proc {
+			delete(p.procs, k)
+		}
+	}
+	return nil
+}
+
+func (p *ProcessRuntime) Inspect(ctx context.Context, id string) (ContainerInfo, error) {
+	proc, err := p.lookup(id)
+	if err != nil {
+		return ContainerInfo{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return proc.info(), nil
+}
+
+// List only knows the processes started by this runtime, since processes
+// are not tracked across restarts of the worker.
+func (p *ProcessRuntime) List(ctx context.Context, labels map[string]string) ([]ContainerInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	seen := make(map[*process]bool)
+	var infos []ContainerInfo
+	for _, proc := range p.procs {
+		if seen[proc] {
+			continue
+		}
+		seen[proc] = true
+		info := proc.info()
+		if info.HasLabels(labels) {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// info returns the current state of proc. The runtime's mu must be held.
+func (proc *process) info() ContainerInfo {
+	info := ContainerInfo{
+		ID:     proc.handle,
+		Name:   proc.config.Name,
+		Labels: proc.config.Labels,
+		Status: "created",
+	}
+	if !proc.started {
+		return info
+	}
+
+	info.ID = strconv.Itoa(proc.cmd.Process.Pid)
+	info.StartedAt = proc.startedAt
+	select {
+	case <-proc.done:
+		info.Status = "exited"
+		info.ExitCode = proc.exitCode
+		info.FinishedAt = proc.finishedAt
+		info.OOMKilled = cgroupOOMKilled(proc.cgroup)
+		if proc.err != nil {
+			info.Error = proc.err.Error()
+		}
+	default:
+		info.Status = "running"
+		info.Running = true
+	}
+	return info
+}
+
+// Logs returns the combined output of the process. Since and Timestamps are
+// not supported since the output is captured without timestamps.
+func (p *ProcessRuntime) Logs(ctx context.Context, id string, opts LogOptions) (io.ReadCloser, error) {
+	proc, err := p.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(proc.logPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	offset := int64(len(data))
+	data = tailLines(data, opts.Tail)
+
+	if !opts.Follow {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := pw.Write(data)
+		for err == nil {
+			offset, err = copyFrom(pw, proc.logPath, offset)
+			if err != nil {
+				break
+			}
+			select {
+			case <-proc.done:
+				_, err = copyFrom(pw, proc.logPath, offset)
+				if err == nil {
+					err = io.EOF
+				}
+			case <-ctx.Done():
+				err = ctx.Err()
+			case <-time.After(500 * time.Millisecond):
+			}
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// Exec runs cmd on the host with the environment of the process with the
+// given id.
+func (p *ProcessRuntime) Exec(ctx context.Context, id string, cmd []string) (ExecResult, error) {
+	proc, err := p.lookup(id)
+	if err != nil {
+		return ExecResult{}, err
+	}
+	if len(cmd) == 0 {
+		return ExecResult{}, errors.New("no command given")
+	}
+
+	c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	c.Env = append(os.Environ(), proc.config.Env...)
+	out, err := c.CombinedOutput()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return ExecResult{}, err
+	}
+	return ExecResult{
+		ExitCode: c.ProcessState.ExitCode(),
+		Output:   string(out),
+	}, nil
+}
+
+// ExecAttach runs cmd on the host with the environment of the process with
+// the given id. Terminals are not supported.
+func (p *ProcessRuntime) ExecAttach(ctx context.Context, id string, opts ExecOptions) (ExecSession, error) {
+	proc, err := p.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Cmd) == 0 {
+		return nil, errors.New("no command given")
+	}
+	if opts.Tty {
+		return nil, errors.New("process runtime does not support terminals")
+	}
+
+	c := exec.Command(opts.Cmd[0], opts.Cmd[1:]...)
+	c.Env = append(os.Environ(), proc.config.Env...)
+	c.Dir = proc.config.WorkingDir
+	pr, pw := io.Pipe()
+	c.Stdout = pw
+	c.Stderr = pw
+	s := &processExec{cmd: c, out: pr, done: make(chan struct{})}
+	if opts.Stdin {
+		s.in, err = c.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = c.Start()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		s.err = c.Wait()
+		pw.Close()
+		close(s.done)
+	}()
+	return s, nil
+}
+
+type processExec struct {
+	cmd  *exec.Cmd
+	in   io.WriteCloser
+	out  io.Reader
+	err  error
+	done chan struct{}
+}
+
+func (e *processExec) Read(p []byte) (int, error) {
+	return e.out.Read(p)
+}
+
+func (e *processExec) Write(p []byte) (int, error) {
+	if e.in == nil {
+		return 0, errors.New("command was started without input")
+	}
+	return e.in.Write(p)
+}
+
+func (e *processExec) CloseWrite() error {
+	if e.in == nil {
+		return nil
+	}
+	return e.in.Close()
+}
+
+func (e *processExec) Resize(ctx context.Context, height, width uint) error {
+	return errors.New("process runtime does not support terminals")
+}
+
+func (e *processExec) Wait(ctx context.Context) (int, error) {
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if e.err != nil && !errors.As(e.err, &exitErr) {
+		return 0, e.err
+	}
+	return e.cmd.ProcessState.ExitCode(), nil
+}
+
+func (e *processExec) Close() error {
+	select {
+	case <-e.done:
+		return nil
+	default:
+	}
+	return e.cmd.Process.Kill()
+}
+
+func copyFrom(w io.Writer, path string, offset int64) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return offset, nil
+		}
+		return offset, err
+	}
+	defer f.Close()
+
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return offset, err
+	}
+	n, err := io.Copy(w, f)
+	return offset + n, err
+}
+
+// tailLines returns the last n lines of data, where n is a number or "all".
+func tailLines(data []byte, n string) []byte {
+	if n == "" || n == "all" {
+		return data
+	}
+	lines, err := strconv.Atoi(n)
+	if err != nil || lines < 0 {
+		return data
+	}
+	if lines == 0 {
+		return nil
+	}
+	end := len(data)
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	for i := end - 1; i >= 0; i-- {
+		if data[i] == '\n' {
+			lines--
+			if lines == 0 {
+				return data[i+1:]
+			}
+		}
+	}
+	return data
+}
+
+func sanitizeName(name string) string {
+	if name == "" {
+		return "task"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,106 @@
+package task
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func setProcAttr(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminate sends SIGTERM to the process group so children exit too.
+func terminate(p *os.Process) error {
+	return syscall.Kill(-p.Pid, syscall.SIGTERM)
+}
+
+func kill(p *os.Process) error {
+	return syscall.Kill(-p.Pid, syscall.SIGKILL)
+}
+
+// setupCgroup creates a cgroup v2 group with memory.max set and arranges for
+// cmd to be started directly inside it.
+func (p *ProcessRuntime) setupCgroup(cmd *exec.Cmd, proc *process) (string, error) {
+	controllers, err := os.ReadFile(filepath.Join(filepath.Dir(p.CgroupRoot), "cgroup.controllers"))
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Contains(controllers, []byte("memory")) {
+		return "", os.ErrNotExist
+	}
+
+	err = os.MkdirAll(p.CgroupRoot, 0o755)
+	if err != nil {
+		return "", err
+	}
+	// Child groups only get the memory controller when it is enabled on
+	// the parent; this fails harmlessly if it already is.
+	os.WriteFile(filepath.Join(p.CgroupRoot, "cgroup.subtree_control"), []byte("+memory"), 0o644)
+
+	dir := filepath.Join(p.CgroupRoot, proc.handle)
+	err = os.Mkdir(dir, 0o755)
+	if err != nil {
+		return "", err
+	}
+
+	limit := strconv.FormatInt(proc.config.Memory, 10)
+	err = os.WriteFile(filepath.Join(dir, "memory.max"), []byte(limit), 0o644)
+	if err != nil {
+		os.Remove(dir)
+		return "", err
+	}
+
+	fd, err := unix.Open(dir, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		os.Remove(dir)
+		return "", err
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = fd
+	return dir, nil
+}
+
+func closeCgroupFD(cmd *exec.Cmd) {
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.UseCgroupFD {
+		unix.Close(cmd.SysProcAttr.CgroupFD)
+	}
+}
+
+// limitMemory caps the address space of cmd when cgroups are unavailable.
+// Go can't set rlimits between fork and exec, so cmd is run through a
+// shell that sets the limit and then execs the command in its place, which
+// keeps the PID and means the command never runs without the limit.
+func limitMemory(cmd *exec.Cmd, bytes int64) error {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		return err
+	}
+	kb := strconv.FormatInt(max(bytes/1024, 1), 10)
+	cmd.Args = append([]string{"sh", "-c", `ulimit -v "$1" && shift && exec "$@"`, "sh", kb, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = sh
+	return nil
+}
+
+func cgroupOOMKilled(dir string) bool {
+	if dir == "" {
+		return false
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "memory.events"))
+	if err != nil {
+		return false
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		fields := bytes.Fields(line)
+		if len(fields) == 2 && string(fields[0]) == "oom_kill" {
+			n, _ := strconv.Atoi(string(fields[1]))
+			return n > 0
+		}
+	}
+	return false
+}
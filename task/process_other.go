@@ -0,0 +1,35 @@
+//go:build !linux
+
+package task
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+var errNoMemoryLimit = errors.New("memory limits are only supported on linux")
+
+func setProcAttr(cmd *exec.Cmd) {}
+
+func terminate(p *os.Process) error {
+	return p.Signal(os.Interrupt)
+}
+
+func kill(p *os.Process) error {
+	return p.Kill()
+}
+
+func (p *ProcessRuntime) setupCgroup(cmd *exec.Cmd, proc *process) (string, error) {
+	return "", errNoMemoryLimit
+}
+
+func closeCgroupFD(cmd *exec.Cmd) {}
+
+func limitMemory(cmd *exec.Cmd, bytes int64) error {
+	return errNoMemoryLimit
+}
+
+func cgroupOOMKilled(dir string) bool {
+	return false
+}
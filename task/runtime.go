@@ -0,0 +1,169 @@
+package task
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+)
+
+// Runtime is what a worker uses to run the containers (or processes) backing
+// its tasks. Docker is the default implementation.
+type Runtime interface {
+	Pull(ctx context.Context, image string) error
+	Create(ctx context.Context, config Config) (string, error)
+	Start(ctx context.Context, id string) error
+	Stop(ctx context.Context, id string) error
+	Remove(ctx context.Context, id string) error
+	Inspect(ctx context.Context, id string) (ContainerInfo, error)
+	Logs(ctx context.Context, id string, opts LogOptions) (io.ReadCloser, error)
+	Exec(ctx context.Context, id string, cmd []string) (ExecResult, error)
+	// List returns the containers carrying all of the given labels.
+	List(ctx context.Context, labels map[string]string) ([]ContainerInfo, error)
+}
+
+// EventSource is implemented by runtimes that report containers exiting as
+// it happens, so the worker doesn't have to wait for its next inspection.
+type EventSource interface {
+	// Exits sends the id of every container that exits until ctx is done
+	// or the subscription fails, in which case the error is sent on the
+	// second channel.
+	Exits(ctx context.Context) (<-chan string, <-chan error)
+}
+
+// ExecAttacher is implemented by runtimes that can run a command in a
+// container and attach to its input and output, e.g. for an interactive
+// shell.
+type ExecAttacher interface {
+	ExecAttach(ctx context.Context, id string, opts ExecOptions) (ExecSession, error)
+}
+
+type ExecOptions struct {
+	Cmd []string
+	// Tty runs the command in a terminal, Stdin attaches its input.
+	Tty   bool
+	Stdin bool
+}
+
+// ExecSession is a command started with ExecAttach. Read returns its
+// output, stdout and stderr combined, until it exits. Write sends it input
+// when it was started with Stdin.
+type ExecSession interface {
+	io.ReadWriter
+	// CloseWrite closes the input of the command.
+	CloseWrite() error
+	// Resize changes the size of the terminal of a command run with Tty.
+	Resize(ctx context.Context, height, width uint) error
+	// Wait waits for the command to exit and returns its exit code.
+	Wait(ctx context.Context) (int, error)
+	// Close detaches from the command, stopping it where the runtime can.
+	Close() error
+}
+
+// ContainerInfo is the runtime's view of a single container.
+type ContainerInfo struct {
+	ID         string
+	Name       string
+	Image      string
+	Labels     map[string]string
+	Status     string
+	Running    bool
+	ExitCode   int
+	OOMKilled  bool
+	Error      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// HostPorts maps the container's exposed ports (e.g. "80/tcp") to the
+	// host ports they are published on.
+	HostPorts map[string]string
+}
+
+// HasLabels reports whether the container carries all of the given labels.
+func (c ContainerInfo) HasLabels(labels map[string]string) bool {
+	for k, v := range labels {
+		if c.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+type ExecResult struct {
+	ExitCode int
+	Output   string
+}
+
+type LogOptions struct {
+	Follow     bool
+	Tail       string
+	Since      string
+	Timestamps bool
+}
+
+type DockerResult struct {
+	Error       error
+	Action      string
+	ContainerID string
+	Result      string
+}
+
+// Run pulls the image for config, then creates and starts a container on rt.
+func Run(rt Runtime, config Config) DockerResult {
+	ctx := context.Background()
+
+	err := rt.Pull(ctx, config.Image)
+	if err != nil {
+		return DockerResult{Error: err}
+	}
+
+	id, err := rt.Create(ctx, config)
+	if err != nil {
+		return DockerResult{Error: err}
+	}
+
+	err = rt.Start(ctx, id)
+	if err != nil {
+		// Leave no container behind that would claim the name when the
+		// task is started again.
+		if rerr := rt.Remove(ctx, id); rerr != nil {
+			log.Printf("Error removing container %s that failed to start: %v", id, rerr)
+		}
+		return DockerResult{Error: err}
+	}
+
+	// Some runtimes only know their real identifier (e.g. a PID) once
+	// started, so prefer whatever Inspect reports.
+	if info, err := rt.Inspect(ctx, id); err == nil && info.ID != "" {
+		id = info.ID
+	}
+
+	return DockerResult{
+		ContainerID: id,
+		Action:      "start",
+		Result:      "success",
+		Error:       nil,
+	}
+}
+
+// Stop stops and removes the container with the given id on rt.
+func Stop(rt Runtime, id string) DockerResult {
+	ctx := context.Background()
+
+	err := rt.Stop(ctx, id)
+	if err != nil {
+		return DockerResult{Error: err}
+	}
+
+	err = rt.Remove(ctx, id)
+	if err != nil {
+		return DockerResult{Error: err}
+	}
+
+	log.Printf("Stopped and removed container %s", id)
+
+	return DockerResult{
+		Action: "stop",
+		Result: "success",
+		Error:  nil,
+	}
+}
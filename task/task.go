@@ -1,17 +1,12 @@
 package task
 
 import (
-	"context"
-	"io"
-	"log"
-	"math"
-	"os"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
 	"time"
 
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
 	"github.com/google/uuid"
 )
@@ -24,29 +19,149 @@ const (
 	Running
 	Failed
 	Completed
+	// Lost tasks were running on a worker the manager can no longer reach.
+	Lost
 )
 
+var stateNames = []string{"Pending", "Scheduled", "Running", "Failed", "Completed", "Lost"}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+	return stateNames[s]
+}
+
 var StateTransitionMap = map[State][]State{
 	Pending:   {Scheduled},
 	Scheduled: {Scheduled, Running, Failed},
 	Running:   {Running, Completed, Failed},
-	Completed: {},
-	Failed:    {},
+	Completed: {Scheduled},
+	Failed:    {Scheduled},
+	Lost:      {Scheduled},
 }
 
 type Task struct {
-	ID            uuid.UUID
-	Name          string
-	ContainerID   string
-	State         State
-	Image         string
-	Memory        int
-	Disk          int
-	ExposedPorts  nat.PortSet
+	ID          uuid.UUID
+	Name        string
+	ContainerID string
+	State       State
+	Reason      string
+	Image       string
+	// Cmd replaces the default command of the image and Args are appended
+	// to it, so Args need a Cmd. Env holds KEY=VALUE pairs set in the
+	// container on top of the image's.
+	Cmd        []string
+	Args       []string
+	Env        []string
+	WorkingDir string
+	User       string
+	// Cpu is the number of CPUs the task may use, zero means no limit.
+	Cpu          float64
+	Memory       int
+	Disk         int
+	Mounts       []Mount
+	ExposedPorts nat.PortSet
+	// PortBindings publishes container ports such as "80/tcp" on the
+	// given host ports, or on free ones when the host port is empty.
+	// HostPorts records the host ports they ended up on.
 	PortBindings  map[string]string
+	HostPorts     map[string]string
 	RestartPolicy string
 	StartTime     time.Time
 	FinishTime    time.Time
+	HealthCheck   *HealthCheck
+	Health        Health
+	// ExitCode, OOMKilled and Error record how the container of the task
+	// exited.
+	ExitCode  int
+	OOMKilled bool
+	Error     string
+	// Mode says whether the task is a long-running service or a job that
+	// is expected to exit.
+	Mode Mode
+	// MaxRestarts is how often the manager restarts the task after it
+	// failed. Zero uses the manager's default for services and disables
+	// retries for jobs, a negative value disables restarts.
+	MaxRestarts  int
+	RestartCount int
+	// Worker is the name of the worker the manager placed the task on.
+	Worker string
+	// Workflow is the workflow the task belongs to, if any. DependsOn
+	// names the tasks of that workflow that must complete before the
+	// task is started.
+	Workflow  uuid.UUID
+	DependsOn []string
+	// Service is the service the task is a replica of, if any, and
+	// Revision the revision of the service it was created from.
+	Service  uuid.UUID
+	Revision int
+}
+
+type Mode string
+
+const (
+	// ModeService tasks run until they are stopped. They are restarted
+	// when they fail.
+	ModeService Mode = ""
+	// ModeJob tasks run to completion: they are Completed when their
+	// container exits with code 0 and Failed otherwise, and are only
+	// retried when they set MaxRestarts.
+	ModeJob Mode = "job"
+)
+
+type Health string
+
+const (
+	HealthUnknown   Health = ""
+	HealthStarting  Health = "starting"
+	HealthHealthy   Health = "healthy"
+	HealthUnhealthy Health = "unhealthy"
+)
+
+const (
+	HealthCheckHTTP = "http"
+	HealthCheckTCP  = "tcp"
+	HealthCheckExec = "exec"
+)
+
+// HealthCheck describes how a worker probes a running task. HTTP checks
+// succeed on a 2xx or 3xx response to a GET of Path on Port, TCP checks when
+// a connection to Port can be opened and exec checks when Command exits
+// with code 0 inside the task's container.
+type HealthCheck struct {
+	Type    string
+	Path    string
+	Port    int
+	Command []string
+	// IntervalSeconds is the time between two checks, TimeoutSeconds how
+	// long a single check may take.
+	IntervalSeconds int
+	TimeoutSeconds  int
+	// FailureThreshold is the number of consecutive failed checks after
+	// which the task is considered unhealthy.
+	FailureThreshold int
+}
+
+func (h *HealthCheck) Interval() time.Duration {
+	if h.IntervalSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(h.IntervalSeconds) * time.Second
+}
+
+func (h *HealthCheck) Timeout() time.Duration {
+	if h.TimeoutSeconds <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(h.TimeoutSeconds) * time.Second
+}
+
+func (h *HealthCheck) Threshold() int {
+	if h.FailureThreshold <= 0 {
+		return 3
+	}
+	return h.FailureThreshold
 }
 
 type TaskEvent struct {
@@ -62,159 +177,86 @@ type Config struct {
 	AttachStdout  bool
 	AttachStderr  bool
 	ExposedPorts  nat.PortSet
+	PortBindings  map[string]string
 	Cmd           []string
 	Image         string
 	Cpu           float64
 	Memory        int64
 	Disk          int64
 	Env           []string
+	WorkingDir    string
+	User          string
+	Mounts        []Mount
 	RestartPolicy string
+	Labels        map[string]string
 }
 
+// Labels put on every container a worker creates, so the worker can find
+// its containers again after a restart.
+const (
+	LabelTaskID   = "cube.task.id"
+	LabelTaskName = "cube.task.name"
+	LabelWorker   = "cube.worker"
+)
+
 func NewConfig(t *Task) Config {
+	restartPolicy := t.RestartPolicy
+	if t.Mode == ModeJob {
+		// Jobs are retried by the manager, the runtime must not restart
+		// them behind its back.
+		restartPolicy = "no"
+	}
 	return Config{
 		Name:          t.Name,
 		Image:         t.Image,
+		Cmd:           append(slices.Clone(t.Cmd), t.Args...),
+		Env:           t.Env,
+		WorkingDir:    t.WorkingDir,
+		User:          t.User,
+		Mounts:        t.Mounts,
+		Cpu:           t.Cpu,
 		Memory:        int64(t.Memory),
 		Disk:          int64(t.Disk),
-		RestartPolicy: t.RestartPolicy,
+		RestartPolicy: restartPolicy,
 		ExposedPorts:  t.ExposedPorts,
+		PortBindings:  t.PortBindings,
 	}
 }
 
-type Docker struct {
-	Client *client.Client
-	Config Config
-}
-
-func NewDocker(config Config) (Docker, error) {
-	dc, err := client.NewClientWithOpts(client.FromEnv)
-	if err != nil {
-		return Docker{}, err
+// Validate checks the settings of t that the manager can't fix up itself.
+func (t *Task) Validate() error {
+	if t.Mode != ModeService && t.Mode != ModeJob {
+		return fmt.Errorf("unknown task mode %q", t.Mode)
 	}
-	return Docker{
-		Client: dc,
-		Config: config,
-	}, nil
-}
-
-type DockerResult struct {
-	Error       error
-	Action      string
-	ContainerID string
-	Result      string
-}
-
-func (d *Docker) Run() DockerResult {
-	ctx := context.Background()
-	reader, err := d.Client.ImagePull(
-		ctx, d.Config.Image, image.PullOptions{})
-
-	if err != nil {
-		log.Printf("Error pulling image %s: %v", d.Config.Image, err)
-		return DockerResult{
-			Error: err,
-		}
-	}
-	io.Copy(os.Stdout, reader)
-	rp := container.RestartPolicy{
-		Name: container.RestartPolicyMode(d.Config.RestartPolicy),
-	}
-
-	r := container.Resources{
-		Memory:   int64(d.Config.Memory),
-		NanoCPUs: int64(d.Config.Cpu * math.Pow(10, 9)),
-	}
-
-	cc := container.Config{
-		Image: d.Config.Image,
-		Tty:   false,
-		Env:   d.Config.Env,
-		Cmd:   d.Config.Cmd,
+	if len(t.Args) > 0 && len(t.Cmd) == 0 {
+		return fmt.Errorf("args are appended to cmd, which is not set")
 	}
-
-	hc := container.HostConfig{
-		RestartPolicy:   rp,
-		Resources:       r,
-		PublishAllPorts: true,
+	if t.Cpu < 0 || t.Memory < 0 || t.Disk < 0 {
+		return fmt.Errorf("cpu, memory and disk must not be negative")
 	}
-
-	resp, err := d.Client.ContainerCreate(
-		ctx,
-		&cc,
-		&hc,
-		nil,
-		nil,
-		d.Config.Name,
-	)
-
-	if err != nil {
-		log.Printf("Error creating container %s from image %s: %v", d.Config.Name, d.Config.Image, err)
-		return DockerResult{
-			Error: err,
-		}
-	}
-
-	err = d.Client.ContainerStart(ctx, resp.ID, container.StartOptions{})
-	if err != nil {
-		log.Printf("Error starting container %s: %v", resp.ID, err)
-		return DockerResult{
-			Error: err,
-		}
-	}
-
-	// d.config.Runtime.ContainerID = resp.ID
-
-	out, err := d.Client.ContainerLogs(ctx, resp.ID, container.LogsOptions{
-		ShowStdout: true,
-		ShowStderr: true,
-	})
-
-	if err != nil {
-		log.Printf("Error getting logs for container %s: %v", resp.ID, err)
-		return DockerResult{
-			Error: err,
+	for _, e := range t.Env {
+		name, _, ok := strings.Cut(e, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid environment variable %q, want KEY=VALUE", e)
 		}
 	}
-
-	stdcopy.StdCopy(os.Stdout, os.Stderr, out)
-
-	return DockerResult{
-		ContainerID: resp.ID,
-		Action:      "start",
-		Result:      "success",
-		Error:       nil,
+	if t.WorkingDir != "" && !path.IsAbs(t.WorkingDir) {
+		return fmt.Errorf("working directory %q must be an absolute path", t.WorkingDir)
 	}
-}
-
-func (d *Docker) Stop(id string) DockerResult {
-	log.Printf("Stopping container %s", id)
-	ctx := context.Background()
-	err := d.Client.ContainerStop(ctx, id, container.StopOptions{})
-	if err != nil {
-		log.Printf("Error stopping container %s: %v", id, err)
-		return DockerResult{
-			Error: err,
+	targets := make(map[string]bool)
+	for _, m := range t.Mounts {
+		if err := m.Validate(); err != nil {
+			return err
 		}
-	}
-
-	err = d.Client.ContainerRemove(ctx, id, container.RemoveOptions{
-		RemoveVolumes: true,
-		RemoveLinks:   false,
-		Force:         false,
-	})
-	if err != nil {
-		log.Printf("Error removing container %s: %v", id, err)
-		return DockerResult{
-			Error: err,
+		if targets[path.Clean(m.Target)] {
+			return fmt.Errorf("more than one mount at %s", m.Target)
 		}
+		targets[path.Clean(m.Target)] = true
 	}
-
-	return DockerResult{
-		Action: "stop",
-		Result: "success",
-		Error:  nil,
+	if _, _, err := ParsePortBindings(t.PortBindings); err != nil {
+		return err
 	}
+	return nil
 }
 
 func Contains(states []State, state State) bool {
@@ -0,0 +1,79 @@
+package task
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		task Task
+		err  string
+	}{
+		{"minimal", Task{Image: "nginx"}, ""},
+		{"job", Task{Image: "alpine", Mode: ModeJob}, ""},
+		{
+			"everything",
+			Task{
+				Image:        "nginx",
+				Cmd:          []string{"nginx"},
+				Args:         []string{"-g", "daemon off;"},
+				Env:          []string{"A=1", "B=", "C=x=y"},
+				WorkingDir:   "/srv",
+				Cpu:          0.5,
+				Memory:       64 << 20,
+				Disk:         1 << 30,
+				Mounts:       []Mount{{Type: MountVolume, Source: "data", Target: "/data"}, {Type: MountBind, Source: "/etc/app", Target: "/etc/app", ReadOnly: true}},
+				PortBindings: map[string]string{"80/tcp": "8080", "53/udp": ""},
+			},
+			"",
+		},
+		{"unknown mode", Task{Image: "nginx", Mode: "daemon"}, `unknown task mode "daemon"`},
+		{"args without cmd", Task{Image: "nginx", Args: []string{"-v"}}, "args are appended to cmd"},
+		{"negative cpu", Task{Image: "nginx", Cpu: -1}, "must not be negative"},
+		{"negative memory", Task{Image: "nginx", Memory: -1}, "must not be negative"},
+		{"negative disk", Task{Image: "nginx", Disk: -1}, "must not be negative"},
+		{"env without value", Task{Image: "nginx", Env: []string{"A"}}, `invalid environment variable "A"`},
+		{"env without name", Task{Image: "nginx", Env: []string{"=1"}}, `invalid environment variable "=1"`},
+		{"relative working dir", Task{Image: "nginx", WorkingDir: "srv"}, `working directory "srv" must be an absolute path`},
+		{"invalid mount", Task{Image: "nginx", Mounts: []Mount{{Type: MountBind, Source: "data", Target: "/data"}}}, "must be an absolute path"},
+		{
+			"mounts at the same target",
+			Task{Image: "nginx", Mounts: []Mount{{Type: MountVolume, Target: "/data"}, {Type: MountVolume, Source: "data", Target: "/data/"}}},
+			"more than one mount at /data/",
+		},
+		{"invalid port", Task{Image: "nginx", PortBindings: map[string]string{"80/tcp": "http"}}, `invalid host port "http"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.task.Validate()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("got error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNewConfig(t *testing.T) {
+	tk := Task{Name: "web", Image: "nginx", Cmd: []string{"nginx"}, Args: []string{"-g", "daemon off;"}, RestartPolicy: "always"}
+	c := NewConfig(&tk)
+	if want := []string{"nginx", "-g", "daemon off;"}; !slices.Equal(c.Cmd, want) {
+		t.Errorf("container command is %q, want %q", c.Cmd, want)
+	}
+	if c.RestartPolicy != "always" {
+		t.Errorf("restart policy of a service is %q, want always", c.RestartPolicy)
+	}
+	if len(tk.Cmd) != 1 {
+		t.Errorf("making the config changed the task command to %q", tk.Cmd)
+	}
+
+	tk.Mode = ModeJob
+	if c := NewConfig(&tk); c.RestartPolicy != "no" {
+		t.Errorf("restart policy of a job is %q, want no", c.RestartPolicy)
+	}
+}
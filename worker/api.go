@@ -22,6 +22,8 @@ func (a *API) initRouter() {
 		r.Get("/", a.GetTaskHandler)
 		r.Route("/{taskID}", func(r chi.Router) {
 			r.Delete("/", a.StopTaskHandler)
+			r.Get("/logs", a.GetTaskLogsHandler)
+			r.Get("/exec", a.ExecTaskHandler)
 		})
 	})
 	a.Router.Route("/stats", func(r chi.Router) {
@@ -0,0 +1,174 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/araminian/cube/task"
+	"github.com/google/uuid"
+	"golang.org/x/net/websocket"
+)
+
+var (
+	errExecDisabled    = errors.New("exec is disabled")
+	errExecUnsupported = errors.New("runtime does not support exec")
+	errTaskNotRunning  = errors.New("task is not running")
+)
+
+// Types of ExecMessage.
+const (
+	ExecStdin  = "stdin"
+	ExecEOF    = "eof"
+	ExecResize = "resize"
+	ExecOutput = "output"
+	ExecExit   = "exit"
+	ExecError  = "error"
+)
+
+// ExecMessage is sent as JSON in both directions over the WebSocket of an
+// exec session. Clients send stdin with Data, eof once their input ends and
+// resize with the new size of their terminal. The worker sends output with
+// Data, then exit with the ExitCode of the command or error when it could
+// not wait for it.
+type ExecMessage struct {
+	Type     string
+	Data     []byte `json:",omitempty"`
+	Height   uint   `json:",omitempty"`
+	Width    uint   `json:",omitempty"`
+	ExitCode int    `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+// ParseExecOptions reads the command to run from the repeated query
+// parameter cmd and whether to run it in a terminal and attach its input
+// from the query parameters tty and stdin.
+func ParseExecOptions(q url.Values) (task.ExecOptions, error) {
+	opts := task.ExecOptions{Cmd: q["cmd"]}
+	if len(opts.Cmd) == 0 || opts.Cmd[0] == "" {
+		return task.ExecOptions{}, fmt.Errorf("cmd is required")
+	}
+	for name, v := range map[string]*bool{"tty": &opts.Tty, "stdin": &opts.Stdin} {
+		if s := q.Get(name); s != "" {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return task.ExecOptions{}, fmt.Errorf("invalid %s %q", name, s)
+			}
+			*v = b
+		}
+	}
+	return opts, nil
+}
+
+// CheckExecOrigin turns down exec requests that pages of other sites make
+// from a browser. Browsers send the origin of the page, which must then be
+// the host the request is made to. Other clients may send no origin, the
+// manager sends the API URL of the worker it dials.
+func CheckExecOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.ParseRequestURI(origin)
+	if err != nil {
+		return fmt.Errorf("invalid origin %q", origin)
+	}
+	if !strings.EqualFold(u.Host, r.Host) {
+		return fmt.Errorf("origin %s may not exec in tasks on %s", origin, r.Host)
+	}
+	return nil
+}
+
+// Exec starts a command in the container of the running task with the
+// given id.
+func (w *Worker) Exec(ctx context.Context, id uuid.UUID, opts task.ExecOptions) (task.ExecSession, error) {
+	attacher, t, err := w.execTarget(id)
+	if err != nil {
+		return nil, err
+	}
+	return attacher.ExecAttach(ctx, t.ContainerID, opts)
+}
+
+// execTarget checks that a command can be run in the task with the given
+// id.
+func (w *Worker) execTarget(id uuid.UUID) (task.ExecAttacher, task.Task, error) {
+	if w.DisableExec {
+		return nil, task.Task{}, errExecDisabled
+	}
+	attacher, ok := w.Runtime.(task.ExecAttacher)
+	if !ok {
+		return nil, task.Task{}, errExecUnsupported
+	}
+	t, err := w.Db.Get(id.String())
+	if err != nil {
+		return nil, task.Task{}, errTaskNotFound
+	}
+	if t.State != task.Running {
+		return nil, task.Task{}, errTaskNotRunning
+	}
+	return attacher, t, nil
+}
+
+// serveExec runs a command in the task with the given id and connects it
+// to ws until the command exits or the
+// client goes away.
+func (w *Worker) serveExec(ws *websocket.Conn, id uuid.UUID, opts task.ExecOptions) {
+	session, err := w.Exec(context.Background(), id, opts)
+	if err != nil {
+		log.Printf("Error starting exec in task %s: %v", id, err)
+		websocket.JSON.Send(ws, ExecMessage{Type: ExecError, Error: err.Error()})
+		return
+	}
+	defer session.Close()
+
+	go func() {
+		for {
+			var msg ExecMessage
+			err := websocket.JSON.Receive(ws, &msg)
+			if err != nil {
+				// Nobody is left to read the output.
+				session.Close()
+				return
+			}
+			switch msg.Type {
+			case ExecStdin:
+				_, err = session.Write(msg.Data)
+			case ExecEOF:
+				err = session.CloseWrite()
+			case ExecResize:
+				err = session.Resize(context.Background(), msg.Height, msg.Width)
+			default:
+				err = fmt.Errorf("unknown message type %q", msg.Type)
+			}
+			if err != nil {
+				log.Printf("Error handling %s message of exec session in task %s: %v", msg.Type, id, err)
+			}
+		}
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := session.Read(buf)
+		if n > 0 {
+			if websocket.JSON.Send(ws, ExecMessage{Type: ExecOutput, Data: buf[:n]}) != nil {
+				return
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	code, err := session.Wait(context.Background())
+	if err != nil {
+		websocket.JSON.Send(ws, ExecMessage{Type: ExecError, Error: err.Error()})
+		return
+	}
+	log.Printf("Audit: exec session in task %s exited with code %d", id, code)
+	websocket.JSON.Send(ws, ExecMessage{Type: ExecExit, ExitCode: code})
+}
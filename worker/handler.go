@@ -3,12 +3,14 @@ package worker
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 
 	"github.com/araminian/cube/task"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"golang.org/x/net/websocket"
 )
 
 type ErrorResponse struct {
@@ -24,17 +26,15 @@ func (a *API) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 	var taskEvent task.TaskEvent
 	err := d.Decode(&taskEvent)
 	if err != nil {
-		msg := fmt.Sprintf("Error decoding task: %s", err)
-		log.Println(msg)
-		e := ErrorResponse{
-			Message:    msg,
-			HTTPStatus: http.StatusBadRequest,
-		}
-		json.NewEncoder(w).Encode(e)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Error decoding task: %s", err))
 		return
 	}
 
-	a.Worker.AddTask(taskEvent.Task)
+	err = a.Worker.ScheduleTask(taskEvent.Task)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Error scheduling task %s: %v", taskEvent.Task.ID, err))
+		return
+	}
 	log.Printf("Task %s added to worker %s\n", taskEvent.Task.ID, a.Worker.Name)
 	w.WriteHeader(http.StatusCreated)
 
@@ -50,42 +50,19 @@ func (a *API) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *API) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
-	taskID := chi.URLParam(r, "taskID")
-	if taskID == "" {
-		log.Println("No task ID provided")
-		e := ErrorResponse{
-			Message:    "No task ID provided",
-			HTTPStatus: http.StatusBadRequest,
-		}
-		json.NewEncoder(w).Encode(e)
-		return
-	}
-
-	tID, err := uuid.Parse(taskID)
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
 	if err != nil {
-		log.Printf("Invalid task ID: %s", taskID)
-		e := ErrorResponse{
-			Message:    "Invalid task ID",
-			HTTPStatus: http.StatusBadRequest,
-		}
-		json.NewEncoder(w).Encode(e)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid task ID: %v", err))
 		return
 	}
 
-	_, ok := a.Worker.Db[tID]
-	if !ok {
-		log.Printf("Task %s not found", tID)
-		e := ErrorResponse{
-			Message:    "Task not found",
-			HTTPStatus: http.StatusNotFound,
-		}
-		json.NewEncoder(w).Encode(e)
+	taskToStop, err := a.Worker.Db.Get(tID.String())
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Task %s not found", tID))
 		return
 	}
 
-	taskToStop := a.Worker.Db[tID]
-
-	taskCopy := *taskToStop
+	taskCopy := taskToStop
 
 	taskCopy.State = task.Completed
 
@@ -102,5 +79,95 @@ func (a *API) GetStatsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
-	json.NewEncoder(w).Encode(a.Worker.Stats)
+	json.NewEncoder(w).Encode(a.Worker.GetStats())
+}
+
+func (a *API) GetTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid task ID: %v", err))
+		return
+	}
+	opts, err := ParseLogOptions(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	logs, err := a.Worker.Logs(r.Context(), id, opts)
+	switch {
+	case err == errTaskNotFound:
+		writeError(w, http.StatusNotFound, "Task not found")
+		return
+	case err == errNoContainer:
+		writeError(w, http.StatusConflict, fmt.Sprintf("Task %s has no container", id))
+		return
+	case err != nil:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Logs of task %s are not available: %v", id, err))
+		return
+	}
+	defer logs.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	_, err = io.Copy(flushWriter{w}, logs)
+	if err != nil && r.Context().Err() == nil {
+		log.Printf("Error streaming logs of task %s: %v", id, err)
+	}
+}
+
+// ExecTaskHandler runs a command in the container of a task and upgrades
+// the connection to a WebSocket carrying ExecMessages.
+func (a *API) ExecTaskHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid task ID: %v", err))
+		return
+	}
+	opts, err := ParseExecOptions(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	err = CheckExecOrigin(r)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	// Check what can be checked before upgrading, so errors get the
+	// right status.
+	_, _, err = a.Worker.execTarget(id)
+	switch {
+	case err == errExecDisabled:
+		writeError(w, http.StatusForbidden, "Exec is disabled on this worker")
+		return
+	case err == errExecUnsupported:
+		writeError(w, http.StatusNotImplemented, "The runtime of this worker does not support exec")
+		return
+	case err == errTaskNotFound:
+		writeError(w, http.StatusNotFound, "Task not found")
+		return
+	case err == errTaskNotRunning:
+		writeError(w, http.StatusConflict, fmt.Sprintf("Task %s is not running", id))
+		return
+	}
+
+	log.Printf("Audit: exec %q in task %s (tty %v, stdin %v) requested by %s", opts.Cmd, id, opts.Tty, opts.Stdin, r.RemoteAddr)
+	websocket.Server{
+		Handler: func(ws *websocket.Conn) {
+			a.Worker.serveExec(ws, id, opts)
+		},
+	}.ServeHTTP(w, r)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	log.Println(msg)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Message:    msg,
+		HTTPStatus: status,
+	})
 }
@@ -0,0 +1,146 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/araminian/cube/task"
+	"github.com/google/uuid"
+)
+
+type healthState struct {
+	lastCheck time.Time
+	failures  int
+}
+
+func (w *Worker) RunHealthChecks() {
+	for {
+		w.runHealthChecks()
+		time.Sleep(time.Second)
+	}
+}
+
+// runHealthChecks probes every running task whose check interval has
+// elapsed. A task failing its check FailureThreshold times in a row is
+// queued to be stopped and marked failed so the manager can restart it.
+func (w *Worker) runHealthChecks() {
+	if w.health == nil {
+		w.health = make(map[uuid.UUID]*healthState)
+	}
+
+	tasks, err := w.Db.List()
+	if err != nil {
+		log.Printf("Error listing tasks for health checks: %v", err)
+		return
+	}
+
+	for _, t := range tasks {
+		id := t.ID
+		if t.State != task.Running || t.HealthCheck == nil {
+			delete(w.health, id)
+			continue
+		}
+
+		hs, ok := w.health[id]
+		if !ok {
+			hs = &healthState{}
+			w.health[id] = hs
+		}
+		if time.Since(hs.lastCheck) < t.HealthCheck.Interval() {
+			continue
+		}
+		hs.lastCheck = time.Now()
+
+		err := w.checkHealth(t)
+		if err == nil {
+			if t.Health != task.HealthHealthy {
+				log.Printf("Task %s is healthy", t.ID)
+				err := w.updateTask(id, func(t *task.Task) bool {
+					if t.State != task.Running {
+						return false
+					}
+					t.Health = task.HealthHealthy
+					return true
+				})
+				if err != nil {
+					log.Printf("Error saving health of task %s: %v", id, err)
+				}
+			}
+			hs.failures = 0
+			continue
+		}
+
+		hs.failures++
+		log.Printf("Health check %d/%d of task %s failed: %v", hs.failures, t.HealthCheck.Threshold(), t.ID, err)
+		if hs.failures < t.HealthCheck.Threshold() {
+			continue
+		}
+
+		t.Health = task.HealthUnhealthy
+		t.Reason = fmt.Sprintf("health check failed %d times: %v", hs.failures, err)
+		t.State = task.Failed
+		delete(w.health, id)
+		w.AddTask(t)
+	}
+}
+
+func (w *Worker) checkHealth(t task.Task) error {
+	hc := t.HealthCheck
+	ctx, cancel := context.WithTimeout(context.Background(), hc.Timeout())
+	defer cancel()
+
+	switch hc.Type {
+	case task.HealthCheckExec:
+		result, err := w.Runtime.Exec(ctx, t.ContainerID, hc.Command)
+		if err != nil {
+			return err
+		}
+		if result.ExitCode != 0 {
+			return fmt.Errorf("command exited with code %d: %s", result.ExitCode, result.Output)
+		}
+		return nil
+	case task.HealthCheckHTTP:
+		url := fmt.Sprintf("http://%s%s", w.healthAddress(ctx, t), hc.Path)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("GET %s returned status %d", url, resp.StatusCode)
+		}
+		return nil
+	case task.HealthCheckTCP:
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", w.healthAddress(ctx, t))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	default:
+		return fmt.Errorf("unknown health check type %q", hc.Type)
+	}
+}
+
+// healthAddress returns the address the health check port of t is reachable
+// at from the worker, i.e. the host port it is published on when there is
+// one and the port itself otherwise.
+func (w *Worker) healthAddress(ctx context.Context, t task.Task) string {
+	port := strconv.Itoa(t.HealthCheck.Port)
+	info, err := w.Runtime.Inspect(ctx, t.ContainerID)
+	if err == nil {
+		if hostPort, ok := info.HostPorts[port+"/tcp"]; ok && hostPort != "" {
+			port = hostPort
+		}
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
@@ -0,0 +1,101 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/araminian/cube/stats"
+)
+
+// Heartbeat is what a worker sends to the manager when it registers and on
+// every heartbeat afterwards.
+type Heartbeat struct {
+	Name  string
+	Api   string
+	Stats stats.Stats
+}
+
+// Register announces the worker, reachable at api, to the manager.
+func (w *Worker) Register(manager string, api string) error {
+	return w.postHeartbeat(fmt.Sprintf("%s/nodes", manager), api, http.StatusCreated)
+}
+
+// Deregister tells the manager the worker is leaving the cluster.
+func (w *Worker) Deregister(manager string) error {
+	url := fmt.Sprintf("%s/nodes/%s", manager, w.Name)
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected status %d deregistering from %s", resp.StatusCode, manager)
+	}
+	return nil
+}
+
+// SendHeartbeats registers the worker with the manager and then keeps
+// sending it the worker's stats. When the manager no longer knows the worker,
+// e.g. after a restart, the worker registers again.
+func (w *Worker) SendHeartbeats(manager string, api string, interval time.Duration) {
+	registered := false
+	for {
+		if !registered {
+			err := w.Register(manager, api)
+			if err != nil {
+				log.Printf("Error registering worker %s with manager %s: %v", w.Name, manager, err)
+			} else {
+				log.Printf("Worker %s registered with manager %s", w.Name, manager)
+				registered = true
+			}
+		} else {
+			url := fmt.Sprintf("%s/nodes/%s/heartbeat", manager, w.Name)
+			err := w.postHeartbeat(url, api, http.StatusOK)
+			if err != nil {
+				log.Printf("Error sending heartbeat to manager %s: %v", manager, err)
+				if err == errUnknownWorker {
+					registered = false
+					continue
+				}
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+var errUnknownWorker = fmt.Errorf("worker not known to manager")
+
+func (w *Worker) postHeartbeat(url string, api string, expected int) error {
+	hb := Heartbeat{
+		Name:  w.Name,
+		Api:   api,
+		Stats: w.GetStats(),
+	}
+	data, err := json.Marshal(hb)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errUnknownWorker
+	}
+	if resp.StatusCode != expected {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+	return nil
+}
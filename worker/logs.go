@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/araminian/cube/task"
+	"github.com/google/uuid"
+)
+
+var (
+	errTaskNotFound = errors.New("task not found")
+	errNoContainer  = errors.New("task has no container")
+)
+
+// Logs returns the output of the container of the task with the given id.
+func (w *Worker) Logs(ctx context.Context, id uuid.UUID, opts task.LogOptions) (io.ReadCloser, error) {
+	t, err := w.Db.Get(id.String())
+	if err != nil {
+		return nil, errTaskNotFound
+	}
+	if t.ContainerID == "" {
+		return nil, errNoContainer
+	}
+	return w.Runtime.Logs(ctx, t.ContainerID, opts)
+}
+
+// ParseLogOptions reads log options from the query parameters tail (a
+// number of lines or "all"), since (an RFC 3339 time, a Unix timestamp or
+// a duration before now, e.g. "10m"), timestamps and follow.
+func ParseLogOptions(q url.Values) (task.LogOptions, error) {
+	opts := task.LogOptions{
+		Tail: q.Get("tail"),
+	}
+	if opts.Tail != "" && opts.Tail != "all" {
+		n, err := strconv.Atoi(opts.Tail)
+		if err != nil || n < 0 {
+			return task.LogOptions{}, fmt.Errorf("invalid tail %q", opts.Tail)
+		}
+	}
+
+	if since := q.Get("since"); since != "" {
+		t, err := parseSince(since, time.Now())
+		if err != nil {
+			return task.LogOptions{}, err
+		}
+		opts.Since = strconv.FormatInt(t.Unix(), 10)
+	}
+
+	for name, v := range map[string]*bool{"timestamps": &opts.Timestamps, "follow": &opts.Follow} {
+		if s := q.Get(name); s != "" {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return task.LogOptions{}, fmt.Errorf("invalid %s %q", name, s)
+			}
+			*v = b
+		}
+	}
+	return opts, nil
+}
+
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid since %q", s)
+}
+
+// flushWriter flushes after every write, so followed logs reach the client
+// as they are written.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if fl, ok := f.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+	return n, err
+}
@@ -0,0 +1,111 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/araminian/cube/task"
+)
+
+// ObserveTasks keeps the state of running tasks in line with their
+// containers, so tasks whose container exited end up Completed or Failed.
+// When the runtime reports exits as they happen they are picked up right
+// away; every running task is also inspected every InspectInterval in case
+// an event was missed or the runtime reports none.
+func (w *Worker) ObserveTasks() {
+	if es, ok := w.Runtime.(task.EventSource); ok {
+		go w.watchExits(es)
+	}
+	for {
+		w.inspectTasks()
+		time.Sleep(w.InspectInterval)
+	}
+}
+
+func (w *Worker) watchExits(es task.EventSource) {
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		exits, errs := es.Exits(ctx)
+		err := w.handleExits(exits, errs)
+		cancel()
+		log.Printf("Error watching container exits, subscribing again in 5 seconds: %v", err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (w *Worker) handleExits(exits <-chan string, errs <-chan error) error {
+	for {
+		select {
+		case id, ok := <-exits:
+			if !ok {
+				return errors.New("exit stream closed")
+			}
+			w.containerExited(id)
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+// containerExited updates the running task whose container has the given
+// id, if there is one.
+func (w *Worker) containerExited(id string) {
+	for _, t := range w.GetTasks() {
+		if t.State == task.Running && t.ContainerID == id {
+			w.observeTask(t)
+			return
+		}
+	}
+}
+
+func (w *Worker) inspectTasks() {
+	for _, t := range w.GetTasks() {
+		if t.State == task.Running {
+			w.observeTask(t)
+		}
+	}
+}
+
+// observeTask inspects the container of the running task t and records
+// how it finished when it is no longer running. The exited container is
+// removed then, so the task can be started again under the same name.
+func (w *Worker) observeTask(t task.Task) {
+	info, err := w.Runtime.Inspect(context.Background(), t.ContainerID)
+	if err != nil {
+		log.Printf("Error inspecting container %s of task %s: %v", t.ContainerID, t.ID, err)
+		return
+	}
+	if info.Running {
+		return
+	}
+
+	finished := false
+	err = w.updateTask(t.ID, func(persisted *task.Task) bool {
+		// The task may have been stopped or restarted since it was listed,
+		// or be stopped right now.
+		if persisted.State != task.Running || persisted.ContainerID != t.ContainerID || w.stopping[t.ID] {
+			return false
+		}
+		finishFromContainer(persisted, info)
+		log.Printf("Container %s of task %s exited with code %d, task is %v", t.ContainerID, t.ID, info.ExitCode, persisted.State)
+		finished = true
+		return true
+	})
+	if err != nil {
+		log.Printf("Error saving state of task %s: %v", t.ID, err)
+		return
+	}
+	if finished {
+		w.removeContainer(t)
+	}
+}
+
+// removeContainer removes the exited container of t.
+func (w *Worker) removeContainer(t task.Task) {
+	err := w.Runtime.Remove(context.Background(), t.ContainerID)
+	if err != nil {
+		log.Printf("Error removing container %s of task %s: %v", t.ContainerID, t.ID, err)
+	}
+}
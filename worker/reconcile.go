@@ -0,0 +1,162 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/araminian/cube/store"
+	"github.com/araminian/cube/task"
+	"github.com/google/uuid"
+)
+
+type OrphanPolicy string
+
+const (
+	// OrphanKeep leaves unknown containers alone and only logs them.
+	OrphanKeep OrphanPolicy = "keep"
+	// OrphanRemove stops and removes unknown containers.
+	OrphanRemove OrphanPolicy = "remove"
+)
+
+// ParseOrphanPolicy returns the policy named s, an empty s is OrphanKeep.
+func ParseOrphanPolicy(s string) (OrphanPolicy, error) {
+	switch p := OrphanPolicy(s); p {
+	case "":
+		return OrphanKeep, nil
+	case OrphanKeep, OrphanRemove:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown orphan policy %q, want %s or %s", s, OrphanKeep, OrphanRemove)
+	}
+}
+
+// Reconcile brings the worker's task db in line with the containers that
+// actually exist, e.g. after the worker restarted. Containers labelled for
+// this worker are adopted as the tasks they were created for, tasks whose
+// container is gone are marked failed and labelled containers that belong
+// to no task are handled according to OrphanPolicy. The manager picks the
+// adopted tasks up from GET /tasks.
+func (w *Worker) Reconcile() error {
+	ctx := context.Background()
+
+	containers, err := w.Runtime.List(ctx, map[string]string{task.LabelWorker: w.Name})
+	if err != nil {
+		return err
+	}
+
+	claimed := make(map[string]bool)
+	for _, c := range containers {
+		id, err := uuid.Parse(c.Labels[task.LabelTaskID])
+		if err != nil {
+			w.handleOrphan(ctx, c, "it has no valid task id")
+			continue
+		}
+
+		t, err := w.Db.Get(id.String())
+		switch {
+		case err == store.ErrNotFound:
+			log.Printf("Adopting container %s of unknown task %s", c.ID, id)
+			t = task.Task{
+				ID:    id,
+				Name:  c.Labels[task.LabelTaskName],
+				Image: c.Image,
+			}
+		case err != nil:
+			return err
+		case t.ContainerID != c.ID:
+			w.handleOrphan(ctx, c, fmt.Sprintf("task %s runs in container %s", id, t.ContainerID))
+			continue
+		case t.State == task.Completed || t.State == task.Failed:
+			if c.Running {
+				w.handleOrphan(ctx, c, fmt.Sprintf("task %s already finished", id))
+			} else {
+				w.removeContainer(t)
+			}
+			continue
+		}
+
+		claimed[c.ID] = true
+		t.ContainerID = c.ID
+		t.StartTime = c.StartedAt
+		t.HostPorts = c.HostPorts
+		if c.Running {
+			t.State = task.Running
+		} else {
+			finishFromContainer(&t, c)
+		}
+		log.Printf("Reconciled task %s with container %s in state %v", t.ID, c.ID, t.State)
+		w.saveTask(t)
+		if !c.Running {
+			w.removeContainer(t)
+		}
+	}
+
+	tasks, err := w.Db.List()
+	if err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		if t.State != task.Running || claimed[t.ContainerID] {
+			continue
+		}
+		// Containers created before they were labelled are only found by id.
+		if c, err := w.Runtime.Inspect(ctx, t.ContainerID); err == nil {
+			if !c.Running {
+				finishFromContainer(&t, c)
+				w.saveTask(t)
+				w.removeContainer(t)
+			}
+			continue
+		}
+		log.Printf("Container %s of task %s no longer exists", t.ContainerID, t.ID)
+		t.State = task.Failed
+		t.FinishTime = time.Now()
+		t.Reason = "container disappeared while the worker was down"
+		w.saveTask(t)
+	}
+	return nil
+}
+
+// finishFromContainer records on t how its exited container c finished.
+func finishFromContainer(t *task.Task, c task.ContainerInfo) {
+	t.FinishTime = c.FinishedAt
+	if t.FinishTime.IsZero() {
+		t.FinishTime = time.Now()
+	}
+	t.ExitCode = c.ExitCode
+	t.OOMKilled = c.OOMKilled
+	t.Error = c.Error
+
+	switch {
+	case c.OOMKilled:
+		t.State = task.Failed
+		t.Reason = "container was killed after running out of memory"
+	case c.Error != "":
+		t.State = task.Failed
+		t.Reason = c.Error
+	case c.ExitCode != 0:
+		t.State = task.Failed
+		t.Reason = fmt.Sprintf("container exited with code %d", c.ExitCode)
+	default:
+		t.State = task.Completed
+		t.Reason = ""
+	}
+}
+
+func (w *Worker) handleOrphan(ctx context.Context, c task.ContainerInfo, why string) {
+	if w.OrphanPolicy != OrphanRemove {
+		log.Printf("Keeping orphaned container %s: %s", c.ID, why)
+		return
+	}
+
+	log.Printf("Removing orphaned container %s: %s", c.ID, why)
+	if c.Running {
+		err := w.Runtime.Stop(ctx, c.ID)
+		if err != nil {
+			return
+		}
+	}
+	w.Runtime.Remove(ctx, c.ID)
+}
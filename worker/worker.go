@@ -1,69 +1,156 @@
 package worker
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/araminian/cube/dispatch"
+	"github.com/araminian/cube/stats"
+	"github.com/araminian/cube/store"
 	"github.com/araminian/cube/task"
-	"github.com/golang-collections/collections/queue"
 	"github.com/google/uuid"
 )
 
+// DefaultParallelism is how many tasks a worker starts or stops at once
+// unless configured otherwise.
+const DefaultParallelism = 4
+
 type Worker struct {
 	Name      string
-	Queue     queue.Queue
-	Db        map[uuid.UUID]*task.Task
+	Queue     *dispatch.Dispatcher[task.Task]
+	Db        store.Store[task.Task]
 	TaskCount int
-	Stats     Stats
-}
+	Stats     stats.Stats
+	Runtime   task.Runtime
+	// OrphanPolicy decides what Reconcile does with containers labelled
+	// for this worker that don't belong to any of its tasks.
+	OrphanPolicy OrphanPolicy
+	// Parallelism is how many tasks RunTask starts or stops at once.
+	// Events for the same task are always handled one after another.
+	Parallelism int
+	// DisableExec rejects requests to run commands in the tasks of the
+	// worker.
+	DisableExec bool
+	// StatsInterval is how often CollectStats collects the stats of the
+	// machine and InspectInterval how often ObserveTasks inspects the
+	// containers of running tasks.
+	StatsInterval   time.Duration
+	InspectInterval time.Duration
 
-func (w *Worker) AddTask(t task.Task) {
-	w.Queue.Enqueue(t)
+	// mu guards Stats, TaskCount and updates of tasks in Db, which the
+	// task executors, the health checks and the API handlers all make.
+	mu sync.Mutex
+	// stopping holds the tasks whose container StopTask or FailTask is
+	// stopping. ObserveTasks leaves them alone, so a container exiting
+	// because it was stopped isn't recorded as a failure. Guarded by mu.
+	stopping map[uuid.UUID]bool
+	// health is only used by RunHealthChecks.
+	health map[uuid.UUID]*healthState
 }
 
-func (w *Worker) RunTask() {
-	for {
-		if w.Queue.Len() > 0 {
-			result := w.runTask()
-			if result.Error != nil {
-				log.Printf("Error running task: %v", result.Error)
-			}
-		} else {
-			log.Printf("No tasks to run on worker %s\n", w.Name)
+// NewWorker creates a worker keeping its tasks in db. Tasks that db holds
+// from a previous run and that were never started are queued again.
+func NewWorker(name string, db store.Store[task.Task], rt task.Runtime) (*Worker, error) {
+	w := &Worker{
+		Name:        name,
+		Db:          db,
+		Runtime:     rt,
+		Parallelism: DefaultParallelism,
+
+		StatsInterval:   15 * time.Second,
+		InspectInterval: 10 * time.Second,
+		stopping:        make(map[uuid.UUID]bool),
+	}
+	w.Queue = dispatch.New(func(t task.Task) string { return t.ID.String() }, w.handleTask)
+
+	tasks, err := db.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tasks {
+		if t.State == task.Scheduled {
+			log.Printf("Requeueing task %s that was scheduled before the worker restarted", t.ID)
+			w.AddTask(t)
 		}
-		log.Printf("Sleeping for 10 seconds\n")
-		time.Sleep(10 * time.Second)
 	}
+	return w, nil
+}
+
+func (w *Worker) AddTask(t task.Task) {
+	w.Queue.Add(t)
 }
 
-func (w *Worker) runTask() task.DockerResult {
-	fromQ := w.Queue.Dequeue()
+// ScheduleTask records t as scheduled, unless the worker knows it already,
+// and queues it to be started. Recording it right away lets a stop that
+// arrives before the start was handled find the task.
+func (w *Worker) ScheduleTask(t task.Task) error {
+	w.mu.Lock()
+	_, err := w.Db.Get(t.ID.String())
+	if err == store.ErrNotFound {
+		err = w.Db.Put(t.ID.String(), t)
+	}
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	w.AddTask(t)
+	return nil
+}
 
-	if fromQ == nil {
-		log.Printf("No task to in the queue")
-		return task.DockerResult{Error: nil}
+// RunTask handles queued tasks as they arrive, up to Parallelism at once.
+func (w *Worker) RunTask() {
+	parallelism := w.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
 	}
+	log.Printf("Worker %s running tasks with parallelism %d", w.Name, parallelism)
+	w.Queue.Run(parallelism)
+}
 
-	taskQueued := fromQ.(task.Task)
+func (w *Worker) handleTask(t task.Task) {
+	result := w.runTask(t)
+	if result.Error != nil {
+		log.Printf("Error running task: %v", result.Error)
+	}
+}
 
-	taskPersisted := w.Db[taskQueued.ID]
-	if taskPersisted == nil {
-		taskPersisted = &taskQueued
-		w.Db[taskQueued.ID] = taskPersisted
+func (w *Worker) runTask(taskQueued task.Task) task.DockerResult {
+	taskPersisted, err := w.Db.Get(taskQueued.ID.String())
+	if err == store.ErrNotFound {
+		taskPersisted = taskQueued
+		err = w.Db.Put(taskQueued.ID.String(), taskPersisted)
+	}
+	if err != nil {
+		return task.DockerResult{Error: err}
 	}
 
 	var result task.DockerResult
 
+	if taskQueued.State == task.Scheduled && taskPersisted.State == task.Running {
+		log.Printf("Task %s is already running in container %s", taskQueued.ID, taskPersisted.ContainerID)
+		return result
+	}
+
 	if task.ValidateStateTransition(
 		taskPersisted.State,
 		taskQueued.State,
 	) {
+		// A stop may have been queued with a copy of the task taken before
+		// the start it follows was handled, so it acts on the persisted
+		// task and the container that start created.
+		stop := taskPersisted
+		stop.State = taskQueued.State
 		switch taskQueued.State {
 		case task.Scheduled:
 			result = w.StartTask(taskQueued)
 		case task.Completed:
-			result = w.StopTask(taskQueued)
+			result = w.StopTask(stop)
+		case task.Failed:
+			stop.Reason = taskQueued.Reason
+			result = w.FailTask(stop)
 		default:
 			log.Printf("Invalid state transition for task %s", taskQueued.ID)
 		}
@@ -78,41 +165,48 @@ func (w *Worker) runTask() task.DockerResult {
 func (w *Worker) StartTask(t task.Task) task.DockerResult {
 
 	t.StartTime = time.Now()
+	t.FinishTime = time.Time{}
+	t.ExitCode = 0
+	t.OOMKilled = false
+	t.Error = ""
 
 	config := task.NewConfig(&t)
-
-	docker, err := task.NewDocker(config)
-	if err != nil {
-		log.Printf("Error creating docker: %+v", err)
-		return task.DockerResult{Error: err}
+	config.Labels = map[string]string{
+		task.LabelTaskID:   t.ID.String(),
+		task.LabelTaskName: t.Name,
+		task.LabelWorker:   w.Name,
 	}
 
-	result := docker.Run()
+	result := task.Run(w.Runtime, config)
 	if result.Error != nil {
 		log.Printf("Error running task %s: %+v\n", t.ID, result.Error)
 		t.State = task.Failed
-		w.Db[t.ID] = &t
+		t.FinishTime = time.Now()
+		t.Reason = result.Error.Error()
+		w.saveTask(t)
 		return result
 	}
 
 	t.ContainerID = result.ContainerID
+	t.HostPorts = nil
+	if info, err := w.Runtime.Inspect(context.Background(), t.ContainerID); err == nil {
+		t.HostPorts = info.HostPorts
+	}
 	t.State = task.Running
-	w.Db[t.ID] = &t
+	t.Reason = ""
+	if t.HealthCheck != nil {
+		t.Health = task.HealthStarting
+	}
+	w.saveTask(t)
 
 	return result
 }
 
 func (w *Worker) StopTask(t task.Task) task.DockerResult {
+	w.setStopping(t.ID, true)
+	defer w.setStopping(t.ID, false)
 
-	config := task.NewConfig(&t)
-
-	docker, err := task.NewDocker(config)
-	if err != nil {
-		log.Printf("Error creating docker: %+v", err)
-		return task.DockerResult{Error: err}
-	}
-
-	result := docker.Stop(t.ContainerID)
+	result := task.Stop(w.Runtime, t.ContainerID)
 
 	if result.Error != nil {
 		log.Printf("Error stopping docker with id %s: %+v", t.ContainerID, result.Error)
@@ -121,17 +215,71 @@ func (w *Worker) StopTask(t task.Task) task.DockerResult {
 
 	t.FinishTime = time.Now()
 	t.State = task.Completed
-	w.Db[t.ID] = &t
+	w.saveTask(t)
 
 	log.Printf("Stopped and Removed container %s for task %s", t.ContainerID, t.ID)
 
 	return result
 }
 
+// FailTask stops the container of a running task that has gone bad, e.g.
+// one failing its health check, and marks the task failed.
+func (w *Worker) FailTask(t task.Task) task.DockerResult {
+	w.setStopping(t.ID, true)
+	defer w.setStopping(t.ID, false)
+
+	result := task.Stop(w.Runtime, t.ContainerID)
+	if result.Error != nil {
+		log.Printf("Error stopping failed task %s: %v", t.ID, result.Error)
+	}
+
+	t.State = task.Failed
+	t.FinishTime = time.Now()
+	w.saveTask(t)
+	log.Printf("Task %s failed: %s", t.ID, t.Reason)
+
+	return result
+}
+
+func (w *Worker) setStopping(id uuid.UUID, stopping bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if stopping {
+		w.stopping[id] = true
+	} else {
+		delete(w.stopping, id)
+	}
+}
+
+func (w *Worker) saveTask(t task.Task) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.Db.Put(t.ID.String(), t)
+	if err != nil {
+		log.Printf("Error saving task %s: %v", t.ID, err)
+	}
+}
+
+// updateTask applies fn to the stored task with the given id and saves the
+// task when fn reports that it changed it.
+func (w *Worker) updateTask(id uuid.UUID, fn func(t *task.Task) bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t, err := w.Db.Get(id.String())
+	if err != nil {
+		return err
+	}
+	if !fn(&t) {
+		return nil
+	}
+	return w.Db.Put(id.String(), t)
+}
+
 func (w *Worker) GetTasks() []task.Task {
-	tasks := []task.Task{}
-	for _, t := range w.Db {
-		tasks = append(tasks, *t)
+	tasks, err := w.Db.List()
+	if err != nil {
+		log.Printf("Error listing tasks: %v", err)
+		return []task.Task{}
 	}
 	return tasks
 }
@@ -139,8 +287,18 @@ func (w *Worker) GetTasks() []task.Task {
 func (w *Worker) CollectStats() {
 	for {
 		log.Printf("Collecting stats in %s", w.Name)
-		w.Stats = getStats()
-		w.Stats.TaskCount = w.TaskCount
-		time.Sleep(15 * time.Second)
+		s := stats.GetStats()
+		w.mu.Lock()
+		s.TaskCount = w.TaskCount
+		w.Stats = s
+		w.mu.Unlock()
+		time.Sleep(w.StatsInterval)
 	}
 }
+
+// GetStats returns the stats last collected by CollectStats.
+func (w *Worker) GetStats() stats.Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.Stats
+}
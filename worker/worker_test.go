@@ -0,0 +1,298 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/araminian/cube/store"
+	"github.com/araminian/cube/task"
+	"github.com/google/uuid"
+)
+
+func newTestWorker(t *testing.T) (*Worker, *task.FakeRuntime) {
+	t.Helper()
+	rt := task.NewFakeRuntime()
+	w, err := NewWorker("test", store.NewMemoryStore[task.Task](), rt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, rt
+}
+
+func newTestTask(name, image string) task.Task {
+	return task.Task{
+		ID:    uuid.New(),
+		Name:  name,
+		Image: image,
+		State: task.Scheduled,
+	}
+}
+
+func getTask(t *testing.T, w *Worker, id uuid.UUID) task.Task {
+	t.Helper()
+	tk, err := w.Db.Get(id.String())
+	if err != nil {
+		t.Fatalf("getting task %s: %v", id, err)
+	}
+	return tk
+}
+
+func TestStartAndStopTask(t *testing.T) {
+	w, rt := newTestWorker(t)
+
+	tk := newTestTask("web", "nginx")
+	if result := w.runTask(tk); result.Error != nil {
+		t.Fatalf("starting task: %v", result.Error)
+	}
+	tk = getTask(t, w, tk.ID)
+	if tk.State != task.Running || tk.ContainerID == "" {
+		t.Fatalf("task is %v in container %q, want Running in a container", tk.State, tk.ContainerID)
+	}
+	info, err := rt.Inspect(context.Background(), tk.ContainerID)
+	if err != nil || !info.Running {
+		t.Fatalf("container of running task: %+v, %v", info, err)
+	}
+	if info.Labels[task.LabelTaskID] != tk.ID.String() || info.Labels[task.LabelWorker] != w.Name {
+		t.Errorf("container labels are %v", info.Labels)
+	}
+
+	tk.State = task.Completed
+	if result := w.runTask(tk); result.Error != nil {
+		t.Fatalf("stopping task: %v", result.Error)
+	}
+	tk = getTask(t, w, tk.ID)
+	if tk.State != task.Completed || tk.FinishTime.IsZero() {
+		t.Errorf("stopped task is %v finished at %v, want Completed", tk.State, tk.FinishTime)
+	}
+	if _, err := rt.Inspect(context.Background(), tk.ContainerID); err == nil {
+		t.Errorf("container %s of stopped task was not removed", tk.ContainerID)
+	}
+}
+
+func TestStopQueuedBeforeStart(t *testing.T) {
+	w, rt := newTestWorker(t)
+
+	tk := newTestTask("web", "nginx")
+	if err := w.ScheduleTask(tk); err != nil {
+		t.Fatal(err)
+	}
+	// The stop is made from the task as it was before it started.
+	stale := getTask(t, w, tk.ID)
+	stale.State = task.Completed
+	w.AddTask(stale)
+
+	go w.RunTask()
+	deadline := time.Now().Add(5 * time.Second)
+	for getTask(t, w, tk.ID).State != task.Completed {
+		if time.Now().After(deadline) {
+			t.Fatalf("task is still %v", getTask(t, w, tk.ID).State)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if containers, _ := rt.List(context.Background(), nil); len(containers) != 0 {
+		t.Errorf("%d containers were left behind", len(containers))
+	}
+}
+
+func TestPullError(t *testing.T) {
+	w, rt := newTestWorker(t)
+	rt.SetBehavior("missing", task.FakeBehavior{PullError: errors.New("image not found")})
+
+	tk := newTestTask("web", "missing")
+	if result := w.runTask(tk); result.Error == nil {
+		t.Fatal("starting task with an image that can't be pulled succeeded")
+	}
+	tk = getTask(t, w, tk.ID)
+	if tk.State != task.Failed || !strings.Contains(tk.Reason, "image not found") {
+		t.Errorf("task is %v because %q, want Failed because the pull failed", tk.State, tk.Reason)
+	}
+	if containers, _ := rt.List(context.Background(), nil); len(containers) != 0 {
+		t.Errorf("%d containers were created", len(containers))
+	}
+}
+
+func TestCrashedTask(t *testing.T) {
+	w, rt := newTestWorker(t)
+	rt.SetBehavior("crash", task.FakeBehavior{CrashAfter: 10 * time.Millisecond, ExitCode: 3})
+	rt.SetBehavior("done", task.FakeBehavior{CrashAfter: 10 * time.Millisecond})
+
+	crash := newTestTask("crash", "crash")
+	done := newTestTask("done", "done")
+	for _, tk := range []task.Task{crash, done} {
+		if result := w.runTask(tk); result.Error != nil {
+			t.Fatalf("starting task %s: %v", tk.Name, result.Error)
+		}
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	w.inspectTasks()
+
+	crash = getTask(t, w, crash.ID)
+	if crash.State != task.Failed || crash.ExitCode != 3 {
+		t.Errorf("crashed task is %v with exit code %d, want Failed with 3", crash.State, crash.ExitCode)
+	}
+	done = getTask(t, w, done.ID)
+	if done.State != task.Completed || done.ExitCode != 0 {
+		t.Errorf("finished task is %v with exit code %d, want Completed with 0", done.State, done.ExitCode)
+	}
+	if containers, _ := rt.List(context.Background(), nil); len(containers) != 0 {
+		t.Errorf("%d exited containers were not removed", len(containers))
+	}
+}
+
+func TestStopIsNotRecordedAsExit(t *testing.T) {
+	w, _ := newTestWorker(t)
+
+	tk := newTestTask("web", "nginx")
+	if result := w.runTask(tk); result.Error != nil {
+		t.Fatalf("starting task: %v", result.Error)
+	}
+	tk = getTask(t, w, tk.ID)
+
+	// The container has exited but the stop has not been saved yet.
+	w.setStopping(tk.ID, true)
+	w.Runtime.Stop(context.Background(), tk.ContainerID)
+	w.inspectTasks()
+	if got := getTask(t, w, tk.ID); got.State != task.Running {
+		t.Errorf("task being stopped is %v, want it left Running", got.State)
+	}
+}
+
+func TestDuplicateName(t *testing.T) {
+	w, _ := newTestWorker(t)
+
+	first := newTestTask("web", "nginx")
+	if result := w.runTask(first); result.Error != nil {
+		t.Fatalf("starting task: %v", result.Error)
+	}
+	second := newTestTask("web", "nginx")
+	if result := w.runTask(second); result.Error == nil {
+		t.Fatal("starting a second task with the same name succeeded")
+	}
+	if got := getTask(t, w, second.ID); got.State != task.Failed {
+		t.Errorf("second task is %v, want Failed", got.State)
+	}
+
+	first = getTask(t, w, first.ID)
+	first.State = task.Completed
+	if result := w.runTask(first); result.Error != nil {
+		t.Fatalf("stopping task: %v", result.Error)
+	}
+	third := newTestTask("web", "nginx")
+	if result := w.runTask(third); result.Error != nil {
+		t.Errorf("starting a task under the name of a stopped one: %v", result.Error)
+	}
+}
+
+func TestConcurrentStartAndStop(t *testing.T) {
+	w, rt := newTestWorker(t)
+	w.InspectInterval = 5 * time.Millisecond
+	rt.Default = task.FakeBehavior{StartDelay: 5 * time.Millisecond}
+	rt.SetBehavior("crash", task.FakeBehavior{CrashAfter: 20 * time.Millisecond, ExitCode: 1})
+	go w.RunTask()
+	go w.ObserveTasks()
+
+	a := &API{Worker: w}
+	a.initRouter()
+	srv := httptest.NewServer(a.Router)
+	defer srv.Close()
+
+	// Keep reading tasks and stats while they change.
+	done := make(chan struct{})
+	var polls sync.WaitGroup
+	for _, path := range []string{"/tasks", "/stats"} {
+		polls.Add(1)
+		go func() {
+			defer polls.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				resp, err := http.Get(srv.URL + path)
+				if err == nil {
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+			}
+		}()
+	}
+
+	const tasks = 20
+	ids := make([]uuid.UUID, tasks)
+	var wg sync.WaitGroup
+	for i := range tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			image := "nginx"
+			if i%4 == 0 {
+				// Crashes around the time it is stopped.
+				image = "crash"
+			}
+			tk := newTestTask(fmt.Sprintf("task-%d", i), image)
+			tk.PortBindings = map[string]string{"80/tcp": strconv.Itoa(20000 + i)}
+			ids[i] = tk.ID
+			data, _ := json.Marshal(task.TaskEvent{ID: uuid.New(), State: task.Scheduled, Timestamp: time.Now(), Task: tk})
+			resp, err := http.Post(srv.URL+"/tasks", "application/json", bytes.NewReader(data))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+
+			// Stop the task right away, most likely before it started.
+			req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/tasks/"+tk.ID.String(), nil)
+			resp, err = http.DefaultClient.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("stopping task %s: status %d", tk.ID, resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, id := range ids {
+		for {
+			tk := getTask(t, w, id)
+			if tk.State == task.Completed || tk.State == task.Failed {
+				if tk.Image == "nginx" && tk.State != task.Completed {
+					t.Errorf("stopped task %s is %v, want Completed", tk.Name, tk.State)
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("task %s is still %v", tk.Name, tk.State)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	close(done)
+	polls.Wait()
+
+	if containers, _ := rt.List(context.Background(), nil); len(containers) != 0 {
+		t.Errorf("%d containers were left behind", len(containers))
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.stopping) != 0 {
+		t.Errorf("%d tasks are still marked as stopping", len(w.stopping))
+	}
+}
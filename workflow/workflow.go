@@ -0,0 +1,110 @@
+package workflow
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/araminian/cube/task"
+	"github.com/google/uuid"
+)
+
+type State string
+
+const (
+	// Pending workflows have not started any of their tasks yet.
+	Pending State = "Pending"
+	// Running workflows have tasks that have not finished yet.
+	Running State = "Running"
+	// Completed workflows had all their tasks complete.
+	Completed State = "Completed"
+	// Failed workflows are done and had at least one task fail.
+	Failed State = "Failed"
+)
+
+// Workflow is a set of tasks that are started in the order given by their
+// DependsOn edges: a task is only started once all the tasks it depends on
+// have completed, and fails without being started when one of them fails.
+// The tasks run as jobs, i.e. they are expected to exit.
+type Workflow struct {
+	ID         uuid.UUID
+	Name       string
+	Tasks      []task.Task
+	State      State
+	CreateTime time.Time
+	// Released holds the tasks that were handed to the scheduler or
+	// failed because of their dependencies.
+	Released map[uuid.UUID]bool
+}
+
+// Validate checks that the tasks of the workflow have unique names and
+// that their dependencies exist and form no cycle.
+func (wf *Workflow) Validate() error {
+	if len(wf.Tasks) == 0 {
+		return fmt.Errorf("workflow has no tasks")
+	}
+
+	names := make(map[string]bool)
+	for _, t := range wf.Tasks {
+		if t.Name == "" {
+			return fmt.Errorf("every task needs a name")
+		}
+		if t.Image == "" {
+			return fmt.Errorf("task %s has no image", t.Name)
+		}
+		if err := t.Validate(); err != nil {
+			return fmt.Errorf("task %s: %v", t.Name, err)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("duplicate task name %s", t.Name)
+		}
+		names[t.Name] = true
+	}
+	for _, t := range wf.Tasks {
+		for _, dep := range t.DependsOn {
+			if !names[dep] {
+				return fmt.Errorf("task %s depends on unknown task %s", t.Name, dep)
+			}
+		}
+	}
+
+	if _, err := wf.Order(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Order returns the names of the tasks of the workflow so that every task
+// comes after the tasks it depends on.
+func (wf *Workflow) Order() ([]string, error) {
+	pending := make(map[string]int)
+	dependents := make(map[string][]string)
+	for _, t := range wf.Tasks {
+		pending[t.Name] = len(t.DependsOn)
+		for _, dep := range t.DependsOn {
+			dependents[dep] = append(dependents[dep], t.Name)
+		}
+	}
+
+	var order, ready []string
+	for _, t := range wf.Tasks {
+		if pending[t.Name] == 0 {
+			ready = append(ready, t.Name)
+		}
+	}
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+		for _, d := range dependents[name] {
+			pending[d]--
+			if pending[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+
+	if len(order) != len(wf.Tasks) {
+		return nil, fmt.Errorf("task dependencies form a cycle")
+	}
+	return order, nil
+}
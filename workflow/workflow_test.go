@@ -0,0 +1,65 @@
+package workflow
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/araminian/cube/task"
+)
+
+func step(name string, deps ...string) task.Task {
+	return task.Task{Name: name, Image: "alpine", DependsOn: deps}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		tasks []task.Task
+		err   string
+	}{
+		{"single", []task.Task{step("a")}, ""},
+		{"diamond", []task.Task{step("d", "b", "c"), step("b", "a"), step("c", "a"), step("a")}, ""},
+		{"empty", nil, "no tasks"},
+		{"no name", []task.Task{step("")}, "needs a name"},
+		{"no image", []task.Task{{Name: "a"}}, "has no image"},
+		{"duplicate", []task.Task{step("a"), step("a")}, "duplicate task name a"},
+		{"unknown dependency", []task.Task{step("a", "b")}, "depends on unknown task b"},
+		{"self", []task.Task{step("a", "a")}, "cycle"},
+		{"cycle", []task.Task{step("a", "c"), step("b", "a"), step("c", "b"), step("d")}, "cycle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wf := Workflow{Tasks: tt.tasks}
+			err := wf.Validate()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("got error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestOrder(t *testing.T) {
+	tests := []struct {
+		tasks []task.Task
+		want  string
+	}{
+		{[]task.Task{step("a"), step("b"), step("c")}, "[a b c]"},
+		{[]task.Task{step("c", "b"), step("b", "a"), step("a")}, "[a b c]"},
+		{[]task.Task{step("d", "b", "c"), step("c", "a"), step("b", "a"), step("a")}, "[a c b d]"},
+	}
+	for _, tt := range tests {
+		wf := Workflow{Tasks: tt.tasks}
+		order, err := wf.Order()
+		if err != nil {
+			t.Errorf("ordering %v: %v", tt.tasks, err)
+			continue
+		}
+		if got := fmt.Sprint(order); got != tt.want {
+			t.Errorf("order is %s, want %s", got, tt.want)
+		}
+	}
+}